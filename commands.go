@@ -17,7 +17,6 @@ import (
 	cmdTLSInit "github.com/hashicorp/consul-k8s/subcommand/tls-init"
 	cmdVersion "github.com/hashicorp/consul-k8s/subcommand/version"
 	webhookCertManager "github.com/hashicorp/consul-k8s/subcommand/webhook-cert-manager"
-	"github.com/hashicorp/consul-k8s/version"
 	"github.com/mitchellh/cli"
 )
 
@@ -65,7 +64,7 @@ func init() {
 		},
 
 		"version": func() (cli.Command, error) {
-			return &cmdVersion.Command{UI: ui, Version: version.GetHumanVersion()}, nil
+			return &cmdVersion.Command{UI: ui}, nil
 		},
 
 		"create-federation-secret": func() (cli.Command, error) {
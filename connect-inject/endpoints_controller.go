@@ -5,11 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 
 	"github.com/deckarep/golang-set"
 	"github.com/go-logr/logr"
 	"github.com/hashicorp/consul-k8s/consul"
+	"github.com/hashicorp/consul-k8s/helper/meta"
 	"github.com/hashicorp/consul-k8s/namespaces"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-multierror"
@@ -364,6 +366,31 @@ func (r *EndpointsController) upsertHealthCheck(pod corev1.Pod, client *api.Clie
 	return nil
 }
 
+// serviceMetaFromAnnotations returns the service meta set by the
+// consul.hashicorp.com/service-meta-<key> annotations in annotations, with
+// the keys sanitized for Consul. Annotations whose keys sanitize to the same
+// meta key, such as service-meta-a.b and service-meta-a_b, must have the same
+// value. Otherwise the meta would depend on map iteration order, so an error
+// is returned instead.
+func serviceMetaFromAnnotations(annotations map[string]string) (map[string]string, error) {
+	var keys []string
+	for k := range annotations {
+		if strings.HasPrefix(k, annotationMeta) && strings.TrimPrefix(k, annotationMeta) != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	metas := make([]map[string]string, 0, len(keys))
+	for _, k := range keys {
+		metas = append(metas, map[string]string{meta.SanitizeMetaKey(strings.TrimPrefix(k, annotationMeta)): annotations[k]})
+	}
+	merged, err := meta.MergeMeta(metas...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotations: %s", annotationMeta, err)
+	}
+	return merged, nil
+}
+
 func getServiceName(pod corev1.Pod, serviceEndpoints corev1.Endpoints) string {
 	serviceName := serviceEndpoints.Name
 	if serviceNameFromAnnotation, ok := pod.Annotations[annotationService]; ok && serviceNameFromAnnotation != "" {
@@ -416,10 +443,12 @@ func (r *EndpointsController) createServiceRegistrations(pod corev1.Pod, service
 		MetaKeyKubeNS:          serviceEndpoints.Namespace,
 		MetaKeyManagedBy:       managedByValue,
 	}
-	for k, v := range pod.Annotations {
-		if strings.HasPrefix(k, annotationMeta) && strings.TrimPrefix(k, annotationMeta) != "" {
-			meta[strings.TrimPrefix(k, annotationMeta)] = v
-		}
+	annotatedMeta, err := serviceMetaFromAnnotations(pod.Annotations)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range annotatedMeta {
+		meta[k] = v
 	}
 
 	var tags []string
@@ -820,6 +820,7 @@ func TestReconcileCreateEndpoint(t *testing.T) {
 				pod1.Annotations[annotationService] = "different-consul-svc-name"
 				pod1.Annotations[fmt.Sprintf("%sname", annotationMeta)] = "abc"
 				pod1.Annotations[fmt.Sprintf("%sversion", annotationMeta)] = "2"
+				pod1.Annotations[fmt.Sprintf("%sapp.kubernetes.io/part-of", annotationMeta)] = "shop"
 				pod1.Annotations[annotationTags] = "abc,123"
 				pod1.Annotations[annotationConnectTags] = "def,456"
 				pod1.Annotations[annotationUpstreams] = "upstream1:1234"
@@ -857,12 +858,13 @@ func TestReconcileCreateEndpoint(t *testing.T) {
 					ServiceAddress: "1.2.3.4",
 					ServicePort:    1234,
 					ServiceMeta: map[string]string{
-						"name":                 "abc",
-						"version":              "2",
-						MetaKeyPodName:         "pod1",
-						MetaKeyKubeServiceName: "service-created",
-						MetaKeyKubeNS:          "default",
-						MetaKeyManagedBy:       managedByValue,
+						"name":                      "abc",
+						"version":                   "2",
+						"app_kubernetes_io_part-of": "shop",
+						MetaKeyPodName:              "pod1",
+						MetaKeyKubeServiceName:      "service-created",
+						MetaKeyKubeNS:               "default",
+						MetaKeyManagedBy:            managedByValue,
 					},
 					ServiceTags: []string{"abc", "123", "def", "456"},
 				},
@@ -890,12 +892,13 @@ func TestReconcileCreateEndpoint(t *testing.T) {
 						},
 					},
 					ServiceMeta: map[string]string{
-						"name":                 "abc",
-						"version":              "2",
-						MetaKeyPodName:         "pod1",
-						MetaKeyKubeServiceName: "service-created",
-						MetaKeyKubeNS:          "default",
-						MetaKeyManagedBy:       managedByValue,
+						"name":                      "abc",
+						"version":                   "2",
+						"app_kubernetes_io_part-of": "shop",
+						MetaKeyPodName:              "pod1",
+						MetaKeyKubeServiceName:      "service-created",
+						MetaKeyKubeNS:               "default",
+						MetaKeyManagedBy:            managedByValue,
 					},
 					ServiceTags: []string{"abc", "123", "def", "456"},
 				},
@@ -4456,3 +4459,54 @@ func createPod(name, ip string, inject bool, managedByEndpointsController bool)
 func toStringPtr(input string) *string {
 	return &input
 }
+
+func TestServiceMetaFromAnnotations(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		annotations map[string]string
+		expMeta     map[string]string
+		expErr      string
+	}{
+		"no meta annotations": {
+			annotations: map[string]string{annotationPort: "8080"},
+			expMeta:     map[string]string{},
+		},
+		"sanitized keys": {
+			annotations: map[string]string{
+				annotationMeta + "version":                   "2",
+				annotationMeta + "app.kubernetes.io/part-of": "shop",
+				annotationMeta:                               "ignored",
+			},
+			expMeta: map[string]string{"version": "2", "app_kubernetes_io_part-of": "shop"},
+		},
+		"colliding keys with the same value": {
+			annotations: map[string]string{
+				annotationMeta + "a.b": "x",
+				annotationMeta + "a_b": "x",
+			},
+			expMeta: map[string]string{"a_b": "x"},
+		},
+		"colliding keys with different values": {
+			annotations: map[string]string{
+				annotationMeta + "a.b": "x",
+				annotationMeta + "a_b": "y",
+			},
+			expErr: `invalid consul.hashicorp.com/service-meta- annotations: conflicting values for meta key "a_b": "x" and "y"`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			// Map iteration order is random, so check the result is stable.
+			for i := 0; i < 10; i++ {
+				meta, err := serviceMetaFromAnnotations(c.annotations)
+				if c.expErr != "" {
+					require.EqualError(t, err, c.expErr)
+					continue
+				}
+				require.NoError(t, err)
+				require.Equal(t, c.expMeta, meta)
+			}
+		})
+	}
+}
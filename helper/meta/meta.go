@@ -0,0 +1,72 @@
+// Package meta handles the metadata attached to Consul services and tokens
+// across commands.
+package meta
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// keyMaxLength is the maximum length of a meta key allowed by Consul.
+	keyMaxLength = 128
+	// keyReservedPrefix is reserved by Consul for its own meta keys.
+	keyReservedPrefix = "consul-"
+)
+
+var (
+	// validKey matches the meta keys Consul accepts.
+	validKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// invalidKeyChars matches characters Consul does not accept in meta keys.
+	invalidKeyChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+)
+
+// MergeMeta merges maps into a single map. The same key may appear in
+// more than one map as long as its value is the same everywhere; otherwise an
+// error naming the first conflicting key is returned. Keys are checked in
+// sorted order so the error is deterministic.
+func MergeMeta(maps ...map[string]string) (map[string]string, error) {
+	merged := make(map[string]string)
+	for _, m := range maps {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if existing, ok := merged[k]; ok && existing != m[k] {
+				return nil, fmt.Errorf("conflicting values for meta key %q: %q and %q", k, existing, m[k])
+			}
+			merged[k] = m[k]
+		}
+	}
+	return merged, nil
+}
+
+// ValidateMetaKey returns an error if Consul would reject k as a meta key.
+func ValidateMetaKey(k string) error {
+	switch {
+	case k == "":
+		return errors.New("meta key cannot be blank")
+	case len(k) > keyMaxLength:
+		return fmt.Errorf("meta key %q is longer than %d characters", k, keyMaxLength)
+	case !validKey.MatchString(k):
+		return fmt.Errorf("meta key %q may only contain alphanumeric characters, underscores and dashes", k)
+	case strings.HasPrefix(k, keyReservedPrefix):
+		return fmt.Errorf("meta key %q uses the reserved prefix %q", k, keyReservedPrefix)
+	}
+	return nil
+}
+
+// SanitizeMetaKey replaces characters Consul does not allow in meta keys with
+// underscores and truncates k to the maximum length Consul allows.
+func SanitizeMetaKey(k string) string {
+	k = invalidKeyChars.ReplaceAllString(k, "_")
+	if len(k) > keyMaxLength {
+		k = k[:keyMaxLength]
+	}
+	return k
+}
@@ -0,0 +1,71 @@
+package meta
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeMeta(t *testing.T) {
+	t.Parallel()
+	merged, err := MergeMeta(
+		map[string]string{"pod": "default/podName"},
+		nil,
+		map[string]string{"env": "prod", "pod": "default/podName"},
+		map[string]string{"team": "infra"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"pod":  "default/podName",
+		"env":  "prod",
+		"team": "infra",
+	}, merged)
+}
+
+func TestMergeMeta_Conflict(t *testing.T) {
+	t.Parallel()
+	_, err := MergeMeta(
+		map[string]string{"pod": "default/podName", "env": "prod"},
+		map[string]string{"pod": "default/otherPod", "env": "dev"},
+	)
+	require.EqualError(t, err, `conflicting values for meta key "env": "prod" and "dev"`)
+}
+
+func TestValidateMetaKey(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"pod-name_1":             "",
+		"":                       "meta key cannot be blank",
+		"app.kubernetes.io/name": `meta key "app.kubernetes.io/name" may only contain alphanumeric characters, underscores and dashes`,
+		"team/owner":             `meta key "team/owner" may only contain alphanumeric characters, underscores and dashes`,
+		"version.major":          `meta key "version.major" may only contain alphanumeric characters, underscores and dashes`,
+		"consul-version":         `meta key "consul-version" uses the reserved prefix "consul-"`,
+		strings.Repeat("a", 129): fmt.Sprintf("meta key %q is longer than 128 characters", strings.Repeat("a", 129)),
+	}
+	for key, expErr := range cases {
+		err := ValidateMetaKey(key)
+		if expErr == "" {
+			require.NoError(t, err)
+		} else {
+			require.EqualError(t, err, expErr)
+		}
+	}
+}
+
+func TestSanitizeMetaKey(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"pod-name_1":             "pod-name_1",
+		"app.kubernetes.io/name": "app_kubernetes_io_name",
+		"team/owner":             "team_owner",
+		"version.major":          "version_major",
+		strings.Repeat("a", 129): strings.Repeat("a", 128),
+	}
+	for key, expected := range cases {
+		sanitized := SanitizeMetaKey(key)
+		require.Equal(t, expected, sanitized)
+		require.NoError(t, ValidateMetaKey(sanitized))
+	}
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// aclDisabledErr is the error message returned by Consul for any ACL endpoint
+// when ACLs are not enabled.
+const aclDisabledErr = "ACL support disabled"
+
+// ACLsEnabled returns true if ACLs are enabled on the Consul servers client
+// talks to. A permission denied error still means ACLs are enabled, so the
+// client does not need a token for this check to work.
+func ACLsEnabled(client *api.Client) (bool, error) {
+	_, _, err := client.ACL().AuthMethodList(nil)
+	if err == nil {
+		return true, nil
+	}
+	if isACLDisabledErr(err) {
+		return false, nil
+	}
+	if strings.Contains(err.Error(), "Permission denied") {
+		return true, nil
+	}
+	return false, err
+}
+
+// isACLDisabledErr returns true if err was returned because ACLs are disabled.
+func isACLDisabledErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), aclDisabledErr)
+}
+
+// RequireAuthMethodType returns an error if the auth method name doesn't
+// exist or its type isn't expectedType, for example "kubernetes" or "jwt".
+// Checking this before logging in surfaces a misconfigured auth method
+// clearly instead of as a failed login.
+func RequireAuthMethodType(client *api.Client, name, expectedType string) error {
+	return requireAuthMethodType(client, name, "", expectedType)
+}
+
+// requireAuthMethodType is RequireAuthMethodType for an auth method in
+// namespace.
+func requireAuthMethodType(client *api.Client, name, namespace, expectedType string) error {
+	method, _, err := client.ACL().AuthMethodRead(name, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		return fmt.Errorf("unable to read auth method %q: %s", name, err)
+	}
+	if method == nil {
+		return fmt.Errorf("auth method %q does not exist", name)
+	}
+	if method.Type != expectedType {
+		return fmt.Errorf("auth method %q has type %q but %q is required", name, method.Type, expectedType)
+	}
+	return nil
+}
+
+// aclAuthorizeRequest is one entry in the body of Consul's
+// /v1/internal/acl/authorize endpoint, and aclAuthorizeResponse is the
+// matching entry in its response.
+type aclAuthorizeRequest struct {
+	Resource string
+	Segment  string
+	Access   string
+}
+
+type aclAuthorizeResponse struct {
+	aclAuthorizeRequest
+	Allow bool
+}
+
+// CanWriteKV returns true if client's token can write keys under prefix. It
+// lets commands that write to KV fail early with a clear error instead of
+// partway through their work.
+func CanWriteKV(client *api.Client, prefix string) (bool, error) {
+	req := []aclAuthorizeRequest{{Resource: "key", Segment: prefix, Access: "write"}}
+	var resp []aclAuthorizeResponse
+	if _, err := client.Raw().Write("/v1/internal/acl/authorize", req, &resp, nil); err != nil {
+		return false, fmt.Errorf("unable to check KV write permission for %q: %s", prefix, err)
+	}
+	if len(resp) != 1 {
+		return false, fmt.Errorf("unable to check KV write permission for %q: expected 1 result but got %d", prefix, len(resp))
+	}
+	return resp[0].Allow, nil
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestACLsEnabled(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		status   int
+		body     string
+		expected bool
+		expErr   bool
+	}{
+		"ACLs disabled": {
+			status:   http.StatusUnauthorized,
+			body:     "ACL support disabled",
+			expected: false,
+		},
+		"permission denied": {
+			status:   http.StatusForbidden,
+			body:     "Permission denied",
+			expected: true,
+		},
+		"allowed": {
+			status:   http.StatusOK,
+			body:     "[]",
+			expected: true,
+		},
+		"server error": {
+			status: http.StatusInternalServerError,
+			body:   "internal error",
+			expErr: true,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			client := startMockACLServer(t, c.status, c.body)
+			enabled, err := ACLsEnabled(client)
+			if c.expErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, enabled)
+		})
+	}
+}
+
+func TestConsulLogin_ACLsDisabled(t *testing.T) {
+	t.Parallel()
+	client := startMockACLServer(t, http.StatusUnauthorized, "ACL support disabled")
+	_, err := ConsulLogin(testLoginConfig(t, client))
+	require.EqualError(t, err, `unable to log in with auth method "consul-k8s-auth-method": ACLs are not enabled on the Consul servers`)
+}
+
+// startMockACLServer starts a server that responds to every request with
+// status and body and returns a Consul client pointing at it.
+func TestRequireAuthMethodType(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		status       int
+		body         string
+		expectedType string
+		expErr       string
+	}{
+		"matching": {
+			status:       http.StatusOK,
+			body:         `{"Name": "consul-k8s-auth-method", "Type": "kubernetes"}`,
+			expectedType: "kubernetes",
+		},
+		"mismatching": {
+			status:       http.StatusOK,
+			body:         `{"Name": "consul-k8s-auth-method", "Type": "jwt"}`,
+			expectedType: "kubernetes",
+			expErr:       `auth method "consul-k8s-auth-method" has type "jwt" but "kubernetes" is required`,
+		},
+		"not found": {
+			status:       http.StatusNotFound,
+			expectedType: "kubernetes",
+			expErr:       `auth method "consul-k8s-auth-method" does not exist`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := startMockACLServer(t, c.status, c.body)
+			err := RequireAuthMethodType(client, testAuthMethod, c.expectedType)
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
+
+func TestCanWriteKV(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		status int
+		body   string
+		exp    bool
+		expErr string
+	}{
+		"allowed": {
+			status: 200,
+			body:   `[{"Resource":"key","Segment":"consul-k8s/","Access":"write","Allow":true}]`,
+			exp:    true,
+		},
+		"denied": {
+			status: 200,
+			body:   `[{"Resource":"key","Segment":"consul-k8s/","Access":"write","Allow":false}]`,
+			exp:    false,
+		},
+		"empty response": {
+			status: 200,
+			body:   `[]`,
+			expErr: `unable to check KV write permission for "consul-k8s/": expected 1 result but got 0`,
+		},
+		"error": {
+			status: 403,
+			body:   "Permission denied",
+			expErr: `unable to check KV write permission for "consul-k8s/": Unexpected response code: 403 (Permission denied)`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := startMockACLServer(t, c.status, c.body)
+			ok, err := CanWriteKV(client, "consul-k8s/")
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, ok)
+		})
+	}
+}
+
+func TestCanWriteKV_Request(t *testing.T) {
+	t.Parallel()
+	var got []map[string]interface{}
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/internal/acl/authorize", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Write([]byte(`[{"Allow":true}]`))
+	})
+
+	ok, err := CanWriteKV(client, "consul-k8s/")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []map[string]interface{}{
+		{"Resource": "key", "Segment": "consul-k8s/", "Access": "write"},
+	}, got)
+}
+
+func startMockACLServer(t *testing.T, status int, body string) *api.Client {
+	t.Helper()
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	})
+	return client
+}
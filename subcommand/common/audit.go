@@ -0,0 +1,69 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// AuditRecord describes a single login attempt. It never contains the
+// token's secret ID or the bearer token.
+type AuditRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	AuthMethod string    `json:"authMethod"`
+	Namespace  string    `json:"namespace,omitempty"`
+	// AccessorID is the accessor ID of the token created by a successful
+	// login.
+	AccessorID string `json:"accessorID,omitempty"`
+	// Outcome is either "success" or "failure".
+	Outcome string `json:"outcome"`
+	// Error is the reason a failed login failed.
+	Error string `json:"error,omitempty"`
+}
+
+// AppendAuditRecord appends rec as a single line of JSON to the file at
+// path, creating it if needed. The file is only ever appended to so that it
+// can serve as an audit trail of logins.
+func AppendAuditRecord(path string, rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("unable to encode audit record: %s", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open audit file: %s", err)
+	}
+	// A single write with O_APPEND keeps concurrent writers from
+	// interleaving within a record.
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write audit record: %s", err)
+	}
+	return f.Close()
+}
+
+// audit appends a record of a login with cfg that returned resp and err to
+// cfg.AuditFile, if set. Failing to do so is logged but doesn't fail the
+// login.
+func audit(cfg LoginConfig, start time.Time, resp *LoginResponse, err error) {
+	if cfg.AuditFile == "" {
+		return
+	}
+	rec := AuditRecord{
+		Timestamp:  start.UTC(),
+		AuthMethod: cfg.AuthMethod,
+		Namespace:  cfg.Namespace,
+		Outcome:    loginOutcomeSuccess,
+	}
+	if resp != nil {
+		rec.AccessorID = resp.AccessorID
+	}
+	if err != nil {
+		rec.Outcome = loginOutcomeFailure
+		rec.Error = err.Error()
+	}
+	if aerr := AppendAuditRecord(cfg.AuditFile, rec); aerr != nil {
+		cfg.logger().Warn("Unable to write login audit record", "error", aerr)
+	}
+}
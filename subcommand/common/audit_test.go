@@ -0,0 +1,53 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsulLogin_AuditFile(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+
+	counter := 0
+	client := startMockServer(t, &counter)
+	for _, bearerToken := range []string{"foo", ""} {
+		ConsulLogin(LoginConfig{
+			Client:          client,
+			BearerTokenFile: WriteTempFile(t, bearerToken),
+			AuthMethod:      testAuthMethod,
+			TokenSinkFile:   WriteTempFile(t, ""),
+			Meta:            testPodMeta,
+			AuditFile:       auditFile,
+		})
+	}
+
+	f, err := os.Open(auditFile)
+	require.NoError(err)
+	defer f.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec AuditRecord
+		require.NoError(json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	require.NoError(scanner.Err())
+	require.Len(records, 2)
+
+	require.Equal(testAuthMethod, records[0].AuthMethod)
+	require.Equal("success", records[0].Outcome)
+	require.Equal("926e2bd2-b344-d91b-0c83-ae89f372cd9b", records[0].AccessorID)
+	require.Empty(records[0].Error)
+	require.False(records[0].Timestamp.IsZero())
+
+	require.Equal("failure", records[1].Outcome)
+	require.Empty(records[1].AccessorID)
+	require.Contains(records[1].Error, "no bearer token found")
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// Backoff wraps a backoff.BackOff so that it is only reset after a run of
+// consecutive successes. This keeps a flapping operation from retrying at the
+// shortest interval every time it succeeds once, while still letting a
+// transient failure after a long healthy period be retried quickly.
+//
+// Backoff is not safe for concurrent use.
+type Backoff struct {
+	backoff.BackOff
+
+	// ResetAfter is the number of consecutive successes after which the
+	// wrapped BackOff is reset. Values below one are treated as one.
+	ResetAfter int
+
+	successes int
+}
+
+// NewBackoff returns a Backoff wrapping b that resets after resetAfter
+// consecutive successes.
+func NewBackoff(b backoff.BackOff, resetAfter int) *Backoff {
+	return &Backoff{BackOff: b, ResetAfter: resetAfter}
+}
+
+// NextBackOff records a failure and returns how long to wait before retrying.
+func (b *Backoff) NextBackOff() time.Duration {
+	b.successes = 0
+	return b.BackOff.NextBackOff()
+}
+
+// Success records a success, resetting the wrapped BackOff once there have
+// been ResetAfter successes in a row.
+func (b *Backoff) Success() {
+	b.successes++
+	if b.successes >= b.ResetAfter {
+		b.BackOff.Reset()
+		b.successes = 0
+	}
+}
+
+// RetryConfig configures the exponential backoff between retries of failed
+// logins. Zero durations and multipliers take the defaults of the backoff
+// package. RandomizationFactor is used as is, so waits are only randomized
+// if it is set.
+type RetryConfig struct {
+	// InitialInterval is the wait after the first failure.
+	InitialInterval time.Duration
+	// Multiplier is what the wait is multiplied by after each failure.
+	Multiplier float64
+	// MaxInterval caps the wait.
+	MaxInterval time.Duration
+	// RandomizationFactor randomizes each wait by up to this fraction of it
+	// in either direction.
+	RandomizationFactor float64
+	// ResetAfter is the ResetAfter of the Backoff returned by NewBackoff.
+	ResetAfter int
+}
+
+// NewBackoff returns a Backoff configured by c, for LoginConfig.Backoff. It
+// keeps backing off for as long as logins fail.
+func (c RetryConfig) NewBackoff() *Backoff {
+	return NewBackoff(c.exponential(), c.ResetAfter)
+}
+
+// exponential returns the backoff.ExponentialBackOff configured by c.
+func (c RetryConfig) exponential() *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	if c.InitialInterval != 0 {
+		b.InitialInterval = c.InitialInterval
+	}
+	if c.Multiplier != 0 {
+		b.Multiplier = c.Multiplier
+	}
+	if c.MaxInterval != 0 {
+		b.MaxInterval = c.MaxInterval
+	}
+	b.RandomizationFactor = c.RandomizationFactor
+	b.MaxElapsedTime = 0
+	b.Reset()
+	return b
+}
+
+// BackoffSchedule returns the waits the Backoff returned by cfg.NewBackoff
+// makes after each of attempts consecutive failures, without waiting. It is
+// meant for documentation and support, to explain how RunLoginLoop will
+// retry. Randomization is left out, so actual waits vary around the schedule
+// by up to cfg.RandomizationFactor.
+func BackoffSchedule(cfg RetryConfig, attempts int) []time.Duration {
+	cfg.RandomizationFactor = 0
+	b := cfg.exponential()
+	schedule := make([]time.Duration, 0, attempts)
+	for i := 0; i < attempts; i++ {
+		schedule = append(schedule, b.NextBackOff())
+	}
+	return schedule
+}
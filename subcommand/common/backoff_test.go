@@ -0,0 +1,119 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff_ResetAfterSustainedSuccess(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	exp := backoff.NewExponentialBackOff()
+	exp.InitialInterval = 1 * time.Second
+	exp.Multiplier = 2
+	exp.RandomizationFactor = 0
+	exp.MaxElapsedTime = 0
+	exp.Reset()
+	b := NewBackoff(exp, 3)
+
+	// Failures increase the backoff.
+	require.Equal(1*time.Second, b.NextBackOff())
+	require.Equal(2*time.Second, b.NextBackOff())
+	require.Equal(4*time.Second, b.NextBackOff())
+
+	// Fewer successes than the threshold don't reset it.
+	b.Success()
+	b.Success()
+	require.Equal(8*time.Second, b.NextBackOff())
+
+	// Sustained success resets it so the next failure retries quickly.
+	b.Success()
+	b.Success()
+	b.Success()
+	require.Equal(1*time.Second, b.NextBackOff())
+}
+
+func TestBackoffSchedule(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		cfg RetryConfig
+		exp []time.Duration
+	}{
+		"configured": {
+			cfg: RetryConfig{
+				InitialInterval:     time.Second,
+				Multiplier:          2,
+				MaxInterval:         10 * time.Second,
+				RandomizationFactor: 0.5,
+			},
+			exp: []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second},
+		},
+		"defaults": {
+			exp: []time.Duration{500 * time.Millisecond, 750 * time.Millisecond, 1125 * time.Millisecond, 1687500 * time.Microsecond, 2531250 * time.Microsecond},
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, c.exp, BackoffSchedule(c.cfg, len(c.exp)))
+		})
+	}
+}
+
+// TestBackoffSchedule_MatchesLoginLoop checks that the schedule is what
+// RunLoginLoop actually waits when every login fails.
+func TestBackoffSchedule_MatchesLoginLoop(t *testing.T) {
+	t.Parallel()
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	cfg := RetryConfig{
+		InitialInterval: time.Second,
+		Multiplier:      3,
+		MaxInterval:     time.Minute,
+		ResetAfter:      1,
+	}
+	const attempts = 5
+	expected := BackoffSchedule(cfg, attempts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := &recordingClock{FakeClock: NewFakeClock(time.Now()), max: attempts, done: cancel}
+	loginCfg := testLoginConfig(t, client)
+	loginCfg.Backoff = cfg.NewBackoff()
+	loginCfg.Clock = clock
+	RunLoginLoop(ctx, loginCfg, time.Hour)
+	require.Equal(t, expected, clock.waits)
+}
+
+// recordingClock is a FakeClock whose After records the durations it is
+// called with and advances the clock by them right away. It calls done after
+// max calls.
+type recordingClock struct {
+	*FakeClock
+	max  int
+	done func()
+
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (c *recordingClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.waits = append(c.waits, d)
+	if len(c.waits) == c.max {
+		c.done()
+	}
+	c.mu.Unlock()
+	ch := c.FakeClock.After(d)
+	c.FakeClock.Advance(d)
+	return ch
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const maxBindNameLength = 256
+
+var (
+	// bindNameVar matches the ${name} variables of a binding rule's
+	// BindName.
+	bindNameVar = regexp.MustCompile(`\$\{([^}]*)\}`)
+	// validBindName matches the service names Consul accepts as the result
+	// of a binding rule with the service bind type.
+	validBindName = regexp.MustCompile(`^[a-z0-9]([a-z0-9\-_]*[a-z0-9])?$`)
+)
+
+// PreviewBindName returns the service name a binding rule's BindName template
+// resolves to, so that operators can check a template before creating the
+// rule. vars holds the values of the variables the auth method provides, such
+// as "serviceaccount.name" and "serviceaccount.namespace" for the Kubernetes
+// auth method. It returns an error if the template uses a variable missing
+// from vars or doesn't resolve to a valid service name.
+func PreviewBindName(template string, vars map[string]string) (string, error) {
+	if strings.Count(template, "${") != len(bindNameVar.FindAllString(template, -1)) {
+		return "", fmt.Errorf("bind name template %q has an unterminated variable", template)
+	}
+	var missing []string
+	name := bindNameVar.ReplaceAllStringFunc(template, func(v string) string {
+		key := strings.TrimSpace(bindNameVar.FindStringSubmatch(v)[1])
+		value, ok := vars[key]
+		if !ok {
+			missing = append(missing, key)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("bind name template %q uses unknown variables: %s", template, strings.Join(missing, ", "))
+	}
+	if len(name) > maxBindNameLength || !validBindName.MatchString(name) {
+		return "", fmt.Errorf("bind name template %q resolves to %q, which is not a valid service name", template, name)
+	}
+	return name, nil
+}
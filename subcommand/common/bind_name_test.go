@@ -0,0 +1,59 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviewBindName(t *testing.T) {
+	t.Parallel()
+	vars := map[string]string{
+		"serviceaccount.name":      "web",
+		"serviceaccount.namespace": "default",
+		"serviceaccount.uid":       "c7a4a9f2",
+	}
+	cases := map[string]struct {
+		template string
+		exp      string
+		expErr   string
+	}{
+		"single variable": {
+			template: "${serviceaccount.name}",
+			exp:      "web",
+		},
+		"several variables": {
+			template: "${serviceaccount.namespace}-${ serviceaccount.name }-svc",
+			exp:      "default-web-svc",
+		},
+		"no variables": {
+			template: "static",
+			exp:      "static",
+		},
+		"unknown variable": {
+			template: "${serviceaccount.name}-${pod.name}",
+			expErr:   `bind name template "${serviceaccount.name}-${pod.name}" uses unknown variables: pod.name`,
+		},
+		"unterminated variable": {
+			template: "${serviceaccount.name",
+			expErr:   `bind name template "${serviceaccount.name" has an unterminated variable`,
+		},
+		"invalid service name": {
+			template: "${serviceaccount.name}.svc",
+			expErr:   `bind name template "${serviceaccount.name}.svc" resolves to "web.svc", which is not a valid service name`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			out, err := PreviewBindName(c.template, vars)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, out)
+		})
+	}
+}
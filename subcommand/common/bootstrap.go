@@ -0,0 +1,36 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// BootstrapTokenPlaceholder is replaced with the ACL token by
+// RenderBootstrap.
+const BootstrapTokenPlaceholder = "${CONSUL_ACL_TOKEN}"
+
+// RenderBootstrap writes the Envoy bootstrap template at templatePath to out
+// with every BootstrapTokenPlaceholder replaced by token. The rendered
+// bootstrap is only ever written to out, so as long as out isn't a file the
+// token never lands on disk. It is an error for the template not to contain
+// the placeholder.
+func RenderBootstrap(templatePath, token string, out io.Writer) error {
+	tmpl, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return fmt.Errorf("unable to read bootstrap template: %s", err)
+	}
+	if !strings.Contains(string(tmpl), BootstrapTokenPlaceholder) {
+		return fmt.Errorf("bootstrap template %s does not contain %s", templatePath, BootstrapTokenPlaceholder)
+	}
+	w := bufio.NewWriter(out)
+	if _, err := strings.NewReplacer(BootstrapTokenPlaceholder, token).WriteString(w, string(tmpl)); err != nil {
+		return fmt.Errorf("unable to write bootstrap: %s", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("unable to write bootstrap: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderBootstrap(t *testing.T) {
+	t.Parallel()
+	tmpl := WriteTempFile(t, `{
+  "dynamic_resources": {
+    "ads_config": {
+      "grpc_services": {
+        "initial_metadata": [{"key": "x-consul-token", "value": "${CONSUL_ACL_TOKEN}"}]
+      }
+    }
+  }
+}`)
+	var out bytes.Buffer
+	require.NoError(t, RenderBootstrap(tmpl, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", &out))
+	require.Contains(t, out.String(), `{"key": "x-consul-token", "value": "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586"}`)
+	require.NotContains(t, out.String(), BootstrapTokenPlaceholder)
+}
+
+func TestRenderBootstrap_NoPlaceholder(t *testing.T) {
+	t.Parallel()
+	tmpl := WriteTempFile(t, `{}`)
+	var out bytes.Buffer
+	err := RenderBootstrap(tmpl, "token", &out)
+	require.EqualError(t, err, "bootstrap template "+tmpl+" does not contain ${CONSUL_ACL_TOKEN}")
+	require.Zero(t, out.Len())
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CheckCAExpiry returns how long is left until the first of the PEM-encoded
+// CA certificates in caPEM expires. It returns an error if that is less than
+// within, or if the certificate has already expired, so that callers can warn
+// before TLS connections to Consul start failing. The time left is returned
+// along with that error.
+func CheckCAExpiry(caPEM []byte, within time.Duration) (time.Duration, error) {
+	return checkCAExpiry(caPEM, within, RealClock{})
+}
+
+// checkCAExpiry is CheckCAExpiry with the current time taken from clock.
+func checkCAExpiry(caPEM []byte, within time.Duration, clock Clock) (time.Duration, error) {
+	var first *x509.Certificate
+	for rest := caPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return 0, fmt.Errorf("unable to parse CA certificate: %s", err)
+		}
+		if first == nil || cert.NotAfter.Before(first.NotAfter) {
+			first = cert
+		}
+	}
+	if first == nil {
+		return 0, errors.New("no certificates found in CA PEM")
+	}
+	left := first.NotAfter.Sub(clock.Now())
+	if left <= 0 {
+		return left, fmt.Errorf("CA certificate %q expired %s ago", first.Subject.CommonName, -left)
+	}
+	if left < within {
+		return left, fmt.Errorf("CA certificate %q expires in %s", first.Subject.CommonName, left)
+	}
+	return left, nil
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCAExpiry(t *testing.T) {
+	t.Parallel()
+	// Certificates only store times to the second.
+	now := time.Now().Truncate(time.Second)
+	soon := generateTestCA(t, "Soon CA", now.Add(time.Hour))
+	later := generateTestCA(t, "Later CA", now.Add(90*24*time.Hour))
+
+	cases := map[string]struct {
+		caPEM   []byte
+		within  time.Duration
+		expLeft time.Duration
+		expErr  string
+	}{
+		"outside window": {
+			caPEM:   later,
+			within:  30 * 24 * time.Hour,
+			expLeft: 90 * 24 * time.Hour,
+		},
+		"inside window": {
+			caPEM:   soon,
+			within:  24 * time.Hour,
+			expLeft: time.Hour,
+			expErr:  `CA certificate "Soon CA" expires in 1h0m0s`,
+		},
+		"earliest of a bundle": {
+			caPEM:   append(append([]byte{}, later...), soon...),
+			within:  24 * time.Hour,
+			expLeft: time.Hour,
+			expErr:  `CA certificate "Soon CA" expires in 1h0m0s`,
+		},
+		"expired": {
+			caPEM:   generateTestCA(t, "Old CA", now.Add(-time.Minute)),
+			within:  24 * time.Hour,
+			expLeft: -time.Minute,
+			expErr:  `CA certificate "Old CA" expired 1m0s ago`,
+		},
+		"no certificates": {
+			caPEM:  []byte("not a pem"),
+			expErr: "no certificates found in CA PEM",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			left, err := checkCAExpiry(c.caPEM, c.within, NewFakeClock(now))
+			require.Equal(t, c.expLeft, left)
+			if c.expErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, c.expErr)
+		})
+	}
+}
+
+// generateTestCA returns a PEM-encoded self-signed CA certificate named
+// commonName that expires at notAfter.
+func generateTestCA(t *testing.T, commonName string, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,494 @@
+package common
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/consul"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-multierror"
+)
+
+// ConsulClientWithCAPEM returns a Consul client for addr that trusts the
+// PEM-encoded CA certificate caPEM. It is useful when the CA is injected
+// inline, for example via an environment variable, rather than as a file.
+func ConsulClientWithCAPEM(addr string, caPEM []byte) (*api.Client, error) {
+	if len(caPEM) == 0 {
+		return nil, errors.New("CA PEM must not be empty")
+	}
+	cfg := api.DefaultConfig()
+	cfg.Address = addr
+	cfg.TLSConfig.CAPem = caPEM
+	return consulClient(cfg)
+}
+
+// ConsulClientFromAddrs returns a Consul client for each address in addrs, in
+// the same order. It is meant to be used with LoginConfig.FailoverClients
+// when -http-addr is given a comma separated list of servers. Other settings,
+// such as TLS, are taken from cfg, which is not modified.
+func ConsulClientFromAddrs(cfg *api.Config, addrs []string) ([]*api.Client, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("at least one address is required")
+	}
+	// Cloning cfg.Transport gives it an empty TLS config if it has none,
+	// which api.NewHttpClient would then keep instead of setting up the one
+	// for cfg.TLSConfig. Set it up first, as api.NewClient would.
+	if cfg.Transport != nil && cfg.Transport.TLSClientConfig == nil {
+		tlsClientConfig, err := api.SetupTLSConfig(&cfg.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Transport.TLSClientConfig = tlsClientConfig
+	}
+	var clients []*api.Client
+	for _, addr := range addrs {
+		addrCfg := *cfg
+		addrCfg.Address = strings.TrimSpace(addr)
+		// newHTTPClient modifies the transport, so each client needs its own.
+		if cfg.Transport != nil {
+			addrCfg.Transport = cfg.Transport.Clone()
+		}
+		client, err := consulClient(&addrCfg)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create client for %s: %s", addr, err)
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// ClientOptions are settings for the Consul clients created by this package
+// that api.Config has no field for.
+type ClientOptions struct {
+	// ForceHTTP11 disables HTTP/2, which is otherwise negotiated with
+	// h2-capable Consul servers and proxies over TLS. It is an escape hatch
+	// for proxies with broken HTTP/2 support.
+	ForceHTTP11 bool
+	// TLSMinVersion is the minimum TLS version accepted from the server, as
+	// one of the tls.Version constants. It defaults to TLS 1.2.
+	TLSMinVersion uint16
+	// TLSCipherSuites, if set, restricts the cipher suites offered for TLS
+	// 1.2 and below, as tls cipher suite IDs, for compliance environments
+	// such as FIPS. TLS 1.3 suites can't be configured.
+	TLSCipherSuites []uint16
+	// DialTimeout, if set, is the maximum time to wait for a connection to
+	// Consul to be established. It defaults to 30 seconds.
+	DialTimeout time.Duration
+	// KeepAlive, if set, is the interval between TCP keep-alive probes. Long
+	// lived connections, such as the sidecar's blocking queries, may need it
+	// tuned separately from the short login path. It defaults to 30 seconds
+	// and a negative value disables keep-alives.
+	KeepAlive time.Duration
+	// UseSystemCAs verifies the server's certificate against the system
+	// trust store, for Consul servers with a publicly trusted certificate. It
+	// can't be used with a CA file, path or PEM in the config's TLS settings.
+	UseSystemCAs bool
+	// Audience, if set, is sent with every login as the audience the bearer
+	// token was issued for, overriding the bound audiences of jwt auth
+	// methods. See JWTLogin.
+	Audience string
+	// MaxResponseBytes, if set, makes requests whose response body is larger
+	// fail instead of reading it all into memory. This protects against a
+	// misbehaving proxy returning a huge body.
+	MaxResponseBytes int64
+	// ConnMetrics, if set, is reported the connection pool events of every
+	// request. See InstrumentConfig.
+	ConnMetrics ConnMetrics
+
+	// systemCertPool is used in tests instead of x509.SystemCertPool.
+	systemCertPool func() (*x509.CertPool, error)
+}
+
+const (
+	// defaultDialTimeout and defaultKeepAlive match the dialer of the
+	// transport used by api.DefaultConfig.
+	defaultDialTimeout = 30 * time.Second
+	defaultKeepAlive   = 30 * time.Second
+)
+
+// dialer returns the dialer for connections to Consul configured by o.
+func (o ClientOptions) dialer() *net.Dialer {
+	d := &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultKeepAlive}
+	if o.DialTimeout != 0 {
+		d.Timeout = o.DialTimeout
+	}
+	if o.KeepAlive != 0 {
+		d.KeepAlive = o.KeepAlive
+	}
+	return d
+}
+
+// ConsulClientWithOptions creates a Consul client from cfg and opts.
+// cfg.Address can be a unix domain socket of the form unix:///path/to/sock.
+func ConsulClientWithOptions(cfg *api.Config, opts ClientOptions) (*api.Client, error) {
+	if cfg.HttpClient == nil {
+		httpClient, err := newHTTPClient(cfg, opts)
+		if err != nil {
+			return nil, err
+		}
+		cfg.HttpClient = httpClient
+		// api.NewClient replaces the HTTP client for unix socket addresses,
+		// which would lose our settings. newHTTPClient already dials the
+		// socket, so give the client a placeholder host instead.
+		if _, ok := unixSocketPath(cfg.Address); ok {
+			cfg.Address = "unix"
+			cfg.Scheme = "http"
+		}
+	}
+	return consul.NewClient(cfg)
+}
+
+// unixSocketPath returns the socket path if addr is of the form
+// unix:///path/to/sock.
+func unixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, "unix://") {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, "unix://"), true
+}
+
+// ValidateServerName returns an error if serverName, the value of
+// -tls-server-name, is set while addr, the value of -http-addr, is a loopback
+// address. That combination is a common mistake: the certificate served on
+// the loopback address usually belongs to the local agent and won't match
+// serverName. It is fine if serverName is the loopback host itself.
+func ValidateServerName(addr, serverName string) error {
+	if serverName == "" {
+		return nil
+	}
+	host := addr
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return fmt.Errorf("unable to parse -http-addr %q: %s", addr, err)
+		}
+		host = u.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == serverName {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return fmt.Errorf("-tls-server-name %q is set but -http-addr %q is a loopback address whose certificate won't match it", serverName, addr)
+	}
+	return nil
+}
+
+// consulClient creates a Consul client from cfg using an HTTP client built by
+// newHTTPClient. All of the client helpers in this package should use it.
+func consulClient(cfg *api.Config) (*api.Client, error) {
+	return ConsulClientWithOptions(cfg, ClientOptions{})
+}
+
+// newHTTPClient returns an HTTP client for cfg's transport and TLS settings
+// that transparently decompresses gzip encoded responses. HTTP/2 is used
+// when the server supports it unless opts.ForceHTTP11 is set. If cfg.Address
+// is a unix socket, all connections are made to it. TLS connections use at
+// least TLS 1.2 unless opts says otherwise and trust the system's CAs if
+// opts.UseSystemCAs is set. Requests made by ConsulLogin carry
+// the ID of the login attempt in the RequestIDHeader header, logins carry
+// opts.Audience, if set, as the audience query parameter, and the
+// Retry-After of rate limited logins is reported back to ConsulLogin.
+func newHTTPClient(cfg *api.Config, opts ClientOptions) (*http.Client, error) {
+	if opts.MaxResponseBytes < 0 {
+		return nil, errors.New("maximum response size must be positive")
+	}
+	transport := cfg.Transport
+	if transport == nil {
+		transport = api.DefaultConfig().Transport
+	}
+	dialer := opts.dialer()
+	if opts.DialTimeout != 0 || opts.KeepAlive != 0 {
+		transport.DialContext = dialer.DialContext
+	}
+	if socket, ok := unixSocketPath(cfg.Address); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+	if opts.ForceHTTP11 {
+		transport.ForceAttemptHTTP2 = false
+		// A non-nil empty map disables HTTP/2 entirely.
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	} else {
+		transport.ForceAttemptHTTP2 = true
+	}
+	httpClient, err := api.NewHttpClient(transport, cfg.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	if opts.UseSystemCAs {
+		if cfg.TLSConfig.CAFile != "" || cfg.TLSConfig.CAPath != "" || len(cfg.TLSConfig.CAPem) > 0 {
+			return nil, errors.New("UseSystemCAs cannot be used with a CA file, path or PEM")
+		}
+		systemCertPool := opts.systemCertPool
+		if systemCertPool == nil {
+			systemCertPool = x509.SystemCertPool
+		}
+		pool, err := systemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load system CA certificates: %s", err)
+		}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if transport.TLSClientConfig != nil {
+		transport.TLSClientConfig.MinVersion = tls.VersionTLS12
+		if opts.TLSMinVersion != 0 {
+			transport.TLSClientConfig.MinVersion = opts.TLSMinVersion
+		}
+		if len(opts.TLSCipherSuites) > 0 {
+			transport.TLSClientConfig.CipherSuites = opts.TLSCipherSuites
+		}
+	}
+	httpClient.Transport = &requestIDTransport{next: &retryAfterTransport{next: &gzipTransport{next: httpClient.Transport}}}
+	if opts.ConnMetrics != nil {
+		httpClient.Transport = &instrumentedTransport{next: httpClient.Transport, metrics: opts.ConnMetrics}
+	}
+	if opts.MaxResponseBytes != 0 {
+		httpClient.Transport = &limitTransport{next: httpClient.Transport, max: opts.MaxResponseBytes}
+	}
+	if opts.Audience != "" {
+		httpClient.Transport = &audienceTransport{audience: opts.Audience, next: httpClient.Transport}
+	}
+	return httpClient, nil
+}
+
+// gzipTransport is an http.RoundTripper that decompresses gzip encoded
+// response bodies. The standard transport only does this if it asked for
+// gzip itself, but some proxies compress responses regardless.
+type gzipTransport struct {
+	next http.RoundTripper
+}
+
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.Uncompressed || !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp, err
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		drainAndClose(resp)
+		return nil, fmt.Errorf("unable to decompress gzip response: %s", err)
+	}
+	resp.Body = &gzipBody{Reader: gz, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// gzipBody reads the decompressed body and closes the underlying body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	// The gzip stream can end before the body does, for example if a proxy
+	// pads it, so drain the rest to be able to reuse the connection.
+	io.Copy(ioutil.Discard, io.LimitReader(b.body, maxDrainBytes))
+	return b.body.Close()
+}
+
+// maxDrainBytes is the most drainAndClose reads from a body. Reading more
+// than this to reuse a connection costs more than opening a new one.
+const maxDrainBytes = 64 << 10
+
+// drainAndClose reads what is left of resp's body, up to maxDrainBytes, and
+// closes it. The transport only reuses a connection once the body on it has
+// been read to the end, so any response we don't pass on to the caller must
+// be closed with this rather than just closing its body.
+func drainAndClose(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+	resp.Body.Close()
+}
+
+// isConnectionErr returns true if err happened because we couldn't connect to
+// the server, as opposed to an error response from it.
+func isConnectionErr(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// IsTransportErr returns true if err happened because no response was
+// received from the server, for example because it couldn't be connected to
+// or the connection broke, as opposed to an error response from it. Requests
+// that are safe to repeat can then be retried against another server.
+func IsTransportErr(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// pingTimeout bounds the preflight check made by ConsulLogin when
+// LoginConfig.Preflight is set.
+const pingTimeout = 5 * time.Second
+
+// PingConsul checks that client can reach Consul by querying the lightweight
+// /v1/status/leader endpoint. Unlike the raw client error, the returned error
+// always starts with "cannot reach Consul at <addr>" so that connectivity
+// problems are easy to tell apart from login failures.
+func PingConsul(ctx context.Context, client *api.Client) error {
+	_, err := client.Status().LeaderWithQueryOptions((&api.QueryOptions{}).WithContext(ctx))
+	if err == nil {
+		return nil
+	}
+	// The client doesn't expose its address, but it is part of the error for
+	// any request that didn't get a response.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if u, perr := url.Parse(urlErr.URL); perr == nil {
+			return fmt.Errorf("cannot reach Consul at %s://%s: %s", u.Scheme, u.Host, urlErr.Err)
+		}
+	}
+	return fmt.Errorf("cannot reach Consul: %s", err)
+}
+
+// preflight pings each of the clients cfg would log in with and returns an
+// error if none of them can reach Consul.
+func preflight(cfg LoginConfig) error {
+	var errs *multierror.Error
+	for _, c := range append([]*api.Client{cfg.Client}, cfg.FailoverClients...) {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err := PingConsul(ctx, c)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		errs = multierror.Append(errs, err)
+	}
+	if len(errs.Errors) == 1 {
+		return errs.Errors[0]
+	}
+	return errs
+}
+
+// ConnMetrics receives connection pool events from a client instrumented
+// with InstrumentConfig.
+type ConnMetrics interface {
+	// GotConn is called each time a request obtains a connection. reused is
+	// true if the connection was previously used for another request and
+	// wasIdle is true if it was taken from the idle pool.
+	GotConn(reused, wasIdle bool)
+}
+
+// ConnStats is a ConnMetrics that counts connections. It is safe for
+// concurrent use.
+type ConnStats struct {
+	total  uint64
+	reused uint64
+	idle   uint64
+}
+
+// GotConn implements ConnMetrics.
+func (s *ConnStats) GotConn(reused, wasIdle bool) {
+	atomic.AddUint64(&s.total, 1)
+	if reused {
+		atomic.AddUint64(&s.reused, 1)
+	}
+	if wasIdle {
+		atomic.AddUint64(&s.idle, 1)
+	}
+}
+
+// Total returns the number of connections obtained.
+func (s *ConnStats) Total() uint64 { return atomic.LoadUint64(&s.total) }
+
+// Reused returns the number of connections obtained that had been used before.
+func (s *ConnStats) Reused() uint64 { return atomic.LoadUint64(&s.reused) }
+
+// Idle returns the number of connections obtained from the idle pool.
+func (s *ConnStats) Idle() uint64 { return atomic.LoadUint64(&s.idle) }
+
+// InstrumentConfig sets cfg.HttpClient to a client built from cfg's transport
+// and TLS settings that reports connection pool events to m. It must be
+// called before the Consul client is created from cfg. To combine it with
+// other ClientOptions, set ClientOptions.ConnMetrics instead.
+func InstrumentConfig(cfg *api.Config, m ConnMetrics) error {
+	httpClient, err := newHTTPClient(cfg, ClientOptions{ConnMetrics: m})
+	if err != nil {
+		return err
+	}
+	cfg.HttpClient = httpClient
+	return nil
+}
+
+// instrumentedTransport is an http.RoundTripper that reports connection
+// events for every request to metrics.
+type instrumentedTransport struct {
+	next    http.RoundTripper
+	metrics ConnMetrics
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.metrics.GotConn(info.Reused, info.WasIdle)
+		},
+	}
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// limitTransport is an http.RoundTripper that limits response bodies to max
+// bytes.
+type limitTransport struct {
+	next http.RoundTripper
+	max  int64
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.ContentLength > t.max {
+		drainAndClose(resp)
+		return nil, fmt.Errorf("response of %d bytes exceeds the maximum of %d bytes", resp.ContentLength, t.max)
+	}
+	// Read at most one byte more than the limit so that we can tell a body
+	// that is exactly max bytes apart from one that is too large.
+	resp.Body = &limitedBody{Reader: io.LimitReader(resp.Body, t.max+1), body: resp.Body, max: t.max}
+	return resp, nil
+}
+
+// limitedBody returns an error once more than max bytes have been read.
+type limitedBody struct {
+	io.Reader
+	body io.ReadCloser
+	max  int64
+	read int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.read += int64(n)
+	if b.read > b.max {
+		return 0, fmt.Errorf("response exceeds the maximum of %d bytes", b.max)
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,611 @@
+package common
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/cert"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsulClientWithCAPEM_EmptyPEM(t *testing.T) {
+	t.Parallel()
+	_, err := ConsulClientWithCAPEM("https://127.0.0.1:8501", nil)
+	require.EqualError(t, err, "CA PEM must not be empty")
+}
+
+// TestConsulClientWithCAPEM ensures that a client built from an inline CA
+// can log in against a TLS server whose certificate is signed by that CA.
+func TestConsulClientWithCAPEM(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	caPEM, certPEM, keyPEM := generateTestCerts(t)
+	counter := 0
+	server := startMockTLSServer(t, certPEM, keyPEM, &counter)
+
+	client, err := ConsulClientWithCAPEM(server.URL, []byte(caPEM))
+	require.NoError(err)
+
+	tokenFile := WriteTempFile(t, "")
+	_, err = ConsulLogin(LoginConfig{
+		Client:          client,
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   tokenFile,
+		Meta:            testPodMeta,
+	})
+	require.NoError(err)
+	require.Equal(1, counter)
+}
+
+// TestConsulLogin_FailoverClients ensures that when the first address can't be
+// reached the login is made against the next one.
+func TestConsulLogin_FailoverClients(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	counter := 0
+	up := startMockLoginServer(t, &counter)
+
+	clients, err := ConsulClientFromAddrs(api.DefaultConfig(), []string{down.URL, up.URL})
+	require.NoError(err)
+	require.Len(clients, 2)
+
+	tokenFile := WriteTempFile(t, "")
+	_, err = ConsulLogin(LoginConfig{
+		Client:          clients[0],
+		FailoverClients: clients[1:],
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   tokenFile,
+		Meta:            testPodMeta,
+	})
+	require.NoError(err)
+	require.Equal(1, counter)
+	data, err := ioutil.ReadFile(tokenFile)
+	require.NoError(err)
+	require.Equal("b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", string(data))
+}
+
+func TestPingConsul(t *testing.T) {
+	t.Parallel()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"127.0.0.1:8300"`))
+	}))
+	t.Cleanup(up.Close)
+	client, err := api.NewClient(&api.Config{Address: up.URL})
+	require.NoError(t, err)
+	require.NoError(t, PingConsul(context.Background(), client))
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	client, err = api.NewClient(&api.Config{Address: down.URL})
+	require.NoError(t, err)
+	err = PingConsul(context.Background(), client)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot reach Consul at "+down.URL+": ")
+	require.Contains(t, err.Error(), "connection refused")
+}
+
+func TestConsulLogin_Preflight(t *testing.T) {
+	t.Parallel()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	client, err := api.NewClient(&api.Config{Address: down.URL})
+	require.NoError(t, err)
+
+	_, err = ConsulLogin(LoginConfig{
+		Client:          client,
+		Preflight:       true,
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   WriteTempFile(t, ""),
+		Meta:            testPodMeta,
+	})
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "cannot reach Consul at "+down.URL+": "), err.Error())
+}
+
+// TestConsulLogin_PreflightValidatesFirst ensures that an incomplete config
+// is reported before the preflight check uses the client.
+func TestConsulLogin_PreflightValidatesFirst(t *testing.T) {
+	t.Parallel()
+	_, err := ConsulLogin(LoginConfig{Preflight: true, AuthMethodFile: "/auth-method", Meta: testPodMeta})
+	require.EqualError(t, err, "login config is missing required settings: Client, BearerTokenFile or BearerTokenFiles, TokenSinkFile, TokenSinkDir or TokenSink")
+}
+
+// TestConsulClientFromAddrs_Config ensures that the clients use the settings
+// of the given config and leave it unchanged.
+func TestConsulClientFromAddrs_Config(t *testing.T) {
+	t.Parallel()
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("X-Consul-Token"))
+		w.Write([]byte(`"127.0.0.1:8300"`))
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := api.DefaultConfig()
+	cfg.Token = "secret"
+	clients, err := ConsulClientFromAddrs(cfg, []string{server.URL, server.URL})
+	require.NoError(t, err)
+	for _, client := range clients {
+		_, err := client.Status().Leader()
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"secret", "secret"}, tokens)
+	require.Equal(t, api.DefaultConfig().Address, cfg.Address)
+	require.Nil(t, cfg.HttpClient)
+}
+
+// Test that every client trusts cfg's CA, also when cfg is reused.
+func TestConsulClientFromAddrs_TLS(t *testing.T) {
+	t.Parallel()
+	caPEM, certPEM, keyPEM := generateTestCerts(t)
+	counter := 0
+	server := startMockTLSServer(t, certPEM, keyPEM, &counter)
+
+	cfg := api.DefaultConfig()
+	cfg.TLSConfig.CAFile = WriteTempFile(t, caPEM)
+	for i := 0; i < 2; i++ {
+		clients, err := ConsulClientFromAddrs(cfg, []string{server.URL, server.URL})
+		require.NoError(t, err)
+		for _, client := range clients {
+			_, _, err := client.ACL().Login(&api.ACLLoginParams{AuthMethod: testAuthMethod, BearerToken: "bearer"}, nil)
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, 4, counter)
+}
+
+func TestIsTransportErr(t *testing.T) {
+	t.Parallel()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(forbidden.Close)
+
+	for addr, exp := range map[string]bool{down.URL: true, forbidden.URL: false} {
+		client, err := api.NewClient(&api.Config{Address: addr})
+		require.NoError(t, err)
+		_, err = client.Status().Leader()
+		require.Error(t, err)
+		require.Equal(t, exp, IsTransportErr(err), err.Error())
+	}
+}
+
+func TestConsulClientFromAddrs_NoAddrs(t *testing.T) {
+	t.Parallel()
+	_, err := ConsulClientFromAddrs(api.DefaultConfig(), nil)
+	require.EqualError(t, err, "at least one address is required")
+}
+
+// TestConsulClient_GzipResponses ensures that clients built by this package
+// can parse a login response that was gzip encoded, whether or not the
+// transport asked for compression.
+func TestConsulClient_GzipResponses(t *testing.T) {
+	t.Parallel()
+	for _, disableCompression := range []bool{false, true} {
+		counter := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v1/acl/login" {
+				counter++
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(testLoginResponse))
+			gz.Close()
+		}))
+		t.Cleanup(server.Close)
+
+		cfg := &api.Config{Address: server.URL, Transport: api.DefaultConfig().Transport}
+		cfg.Transport.DisableCompression = disableCompression
+		client, err := consulClient(cfg)
+		require.NoError(t, err)
+
+		tokenFile := WriteTempFile(t, "")
+		_, err = ConsulLogin(LoginConfig{
+			Client:          client,
+			BearerTokenFile: WriteTempFile(t, "foo"),
+			AuthMethod:      testAuthMethod,
+			TokenSinkFile:   tokenFile,
+			Meta:            testPodMeta,
+		})
+		require.NoError(t, err, "disableCompression=%t", disableCompression)
+		require.Equal(t, 1, counter)
+		data, err := ioutil.ReadFile(tokenFile)
+		require.NoError(t, err)
+		require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", string(data))
+	}
+}
+
+// TestConsulClientWithOptions_HTTP2 ensures that HTTP/2 is negotiated with an
+// h2-capable server unless ForceHTTP11 is set.
+func TestConsulClientWithOptions_HTTP2(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		forceHTTP11 bool
+		expProto    string
+	}{
+		"default": {
+			expProto: "HTTP/2.0",
+		},
+		"force HTTP/1.1": {
+			forceHTTP11: true,
+			expProto:    "HTTP/1.1",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			protos := make(chan string, 1)
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				protos <- r.Proto
+				w.Write([]byte(`"127.0.0.1:8300"`))
+			}))
+			server.EnableHTTP2 = true
+			server.StartTLS()
+			t.Cleanup(server.Close)
+
+			cfg := &api.Config{Address: server.URL}
+			cfg.TLSConfig.InsecureSkipVerify = true
+			client, err := ConsulClientWithOptions(cfg, ClientOptions{ForceHTTP11: c.forceHTTP11})
+			require.NoError(t, err)
+			_, err = client.Status().Leader()
+			require.NoError(t, err)
+			require.Equal(t, c.expProto, <-protos)
+		})
+	}
+}
+
+// TestConsulClientWithOptions_TLSMinVersion ensures that servers below the
+// minimum TLS version are rejected. It defaults to TLS 1.2.
+func TestConsulClientWithOptions_TLSMinVersion(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		serverMaxVersion uint16
+		minVersion       uint16
+		expErr           bool
+	}{
+		"TLS 1.1 server, default": {serverMaxVersion: tls.VersionTLS11, expErr: true},
+		"TLS 1.1 server, TLS 1.2": {serverMaxVersion: tls.VersionTLS11, minVersion: tls.VersionTLS12, expErr: true},
+		"TLS 1.2 server, default": {serverMaxVersion: tls.VersionTLS12},
+		"TLS 1.2 server, TLS 1.3": {serverMaxVersion: tls.VersionTLS12, minVersion: tls.VersionTLS13, expErr: true},
+		"TLS 1.3 server, TLS 1.3": {serverMaxVersion: tls.VersionTLS13, minVersion: tls.VersionTLS13},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`"127.0.0.1:8300"`))
+			}))
+			server.TLS = &tls.Config{MaxVersion: c.serverMaxVersion}
+			server.StartTLS()
+			t.Cleanup(server.Close)
+
+			cfg := &api.Config{Address: server.URL}
+			cfg.TLSConfig.InsecureSkipVerify = true
+			client, err := ConsulClientWithOptions(cfg, ClientOptions{TLSMinVersion: c.minVersion})
+			require.NoError(t, err)
+			_, err = client.Status().Leader()
+			if c.expErr {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "protocol version")
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestConsulClientWithOptions_UseSystemCAs ensures that the system pool is
+// used to verify the server when no CA is configured.
+func TestConsulClientWithOptions_UseSystemCAs(t *testing.T) {
+	t.Parallel()
+	caPEM, certPEM, keyPEM := generateTestCerts(t)
+	counter := 0
+	server := startMockTLSServer(t, certPEM, keyPEM, &counter)
+	systemCertPool := func() (*x509.CertPool, error) {
+		pool := x509.NewCertPool()
+		require.True(t, pool.AppendCertsFromPEM([]byte(caPEM)))
+		return pool, nil
+	}
+
+	cases := map[string]struct {
+		opts   ClientOptions
+		expErr string
+	}{
+		"system CAs": {
+			opts: ClientOptions{UseSystemCAs: true, systemCertPool: systemCertPool},
+		},
+		"no system CAs": {
+			opts:   ClientOptions{systemCertPool: systemCertPool},
+			expErr: "certificate signed by unknown authority",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client, err := ConsulClientWithOptions(&api.Config{Address: server.URL}, c.opts)
+			require.NoError(t, err)
+			_, err = ConsulLogin(testLoginConfig(t, client))
+			if c.expErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.expErr)
+		})
+	}
+
+	cfg := &api.Config{Address: server.URL}
+	cfg.TLSConfig.CAPem = []byte(caPEM)
+	_, err := ConsulClientWithOptions(cfg, ClientOptions{UseSystemCAs: true})
+	require.EqualError(t, err, "UseSystemCAs cannot be used with a CA file, path or PEM")
+}
+
+// TestConsulClientWithOptions_UnixSocket ensures that a client for a unix
+// socket address can log in.
+func TestConsulClientWithOptions_UnixSocket(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	socket := filepath.Join(t.TempDir(), "consul.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(err)
+	counter := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/acl/login" {
+			counter++
+		}
+		w.Write([]byte(testLoginResponse))
+	}))
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	client, err := ConsulClientWithOptions(&api.Config{Address: "unix://" + socket}, ClientOptions{})
+	require.NoError(err)
+	tokenFile := WriteTempFile(t, "")
+	_, err = ConsulLogin(LoginConfig{
+		Client:          client,
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   tokenFile,
+		Meta:            testPodMeta,
+	})
+	require.NoError(err)
+	require.Equal(1, counter)
+	data, err := ioutil.ReadFile(tokenFile)
+	require.NoError(err)
+	require.Equal("b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", string(data))
+}
+
+// TestInstrumentConfig ensures that connection reuse is reported after several
+// requests through the same client.
+func TestInstrumentConfig(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	counter := 0
+	server := startMockLoginServer(t, &counter)
+	cfg := &api.Config{Address: server.URL}
+	stats := &ConnStats{}
+	require.NoError(InstrumentConfig(cfg, stats))
+	client, err := api.NewClient(cfg)
+	require.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		_, err = ConsulLogin(testLoginConfig(t, client))
+		require.NoError(err)
+	}
+	require.Equal(3, counter)
+	require.Equal(uint64(3), stats.Total())
+	require.NotZero(stats.Reused())
+	require.NotZero(stats.Idle())
+}
+
+func TestClientOptions_MaxResponseBytes(t *testing.T) {
+	t.Parallel()
+	// Pad a valid login response with leading whitespace so that it still
+	// decodes if the limit isn't enforced.
+	oversized := strings.Repeat(" ", 4096) + testLoginResponse
+	cases := map[string]struct {
+		body          string
+		contentLength bool
+		expErr        string
+	}{
+		"under the limit": {
+			body: testLoginResponse,
+		},
+		"oversized with content length": {
+			body:          oversized,
+			contentLength: true,
+			expErr:        "exceeds the maximum of 2048 bytes",
+		},
+		"oversized without content length": {
+			body:   oversized,
+			expErr: "response exceeds the maximum of 2048 bytes",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if c.contentLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(c.body)))
+				} else {
+					// Flushing forces a chunked response with no length.
+					w.(http.Flusher).Flush()
+				}
+				w.Write([]byte(c.body))
+			}))
+			t.Cleanup(server.Close)
+
+			client, err := ConsulClientWithOptions(&api.Config{Address: server.URL}, ClientOptions{MaxResponseBytes: 2048})
+			require.NoError(t, err)
+
+			_, err = ConsulLogin(testLoginConfig(t, client))
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
+
+func TestClientOptions_NegativeMaxResponseBytes(t *testing.T) {
+	t.Parallel()
+	_, err := ConsulClientWithOptions(&api.Config{Address: "127.0.0.1:8500"}, ClientOptions{MaxResponseBytes: -1})
+	require.EqualError(t, err, "maximum response size must be positive")
+}
+
+// TestClientOptions_MaxResponseBytesReusesConnection ensures that the body of a rejected
+// response is drained so that the next login can reuse the connection.
+func TestClientOptions_MaxResponseBytesReusesConnection(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	oversized := strings.Repeat(" ", 4096) + testLoginResponse
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(oversized)))
+			w.Write([]byte(oversized))
+			return
+		}
+		w.Write([]byte(testLoginResponse))
+	}))
+	t.Cleanup(server.Close)
+
+	stats := &ConnStats{}
+	client, err := ConsulClientWithOptions(&api.Config{Address: server.URL}, ClientOptions{ConnMetrics: stats, MaxResponseBytes: 2048})
+	require.NoError(err)
+
+	login := func() error {
+		_, err := ConsulLogin(testLoginConfig(t, client))
+		return err
+	}
+	require.Error(login())
+	require.NoError(login())
+	// The failed login's diagnostics make requests too, but they must all
+	// share a single connection.
+	require.Equal(uint64(1), stats.Total()-stats.Reused())
+}
+
+// generateTestCerts returns a CA certificate and a server certificate and key
+// for 127.0.0.1 signed by it, all PEM encoded.
+func generateTestCerts(t *testing.T) (string, string, string) {
+	t.Helper()
+	signer, _, caPEM, caTemplate, err := cert.GenerateCA("Consul Agent CA - Test")
+	require.NoError(t, err)
+	certPEM, keyPEM, err := cert.GenerateCert("server.dc1.consul", 1*time.Hour, caTemplate, signer, []string{"localhost", "127.0.0.1"})
+	require.NoError(t, err)
+	return caPEM, certPEM, keyPEM
+}
+
+// startMockTLSServer starts an httptest TLS server serving certPEM that mocks
+// Consul's /v1/acl/login endpoint. apiCallCounter is incremented on each login.
+func startMockTLSServer(t *testing.T, certPEM, keyPEM string, apiCallCounter *int) *httptest.Server {
+	t.Helper()
+	keyPair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r != nil && r.URL.Path == "/v1/acl/login" && r.Method == "POST" {
+			*apiCallCounter++
+		}
+		w.Write([]byte(testLoginResponse))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{keyPair}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientOptions_Dialer(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		opts         ClientOptions
+		expTimeout   time.Duration
+		expKeepAlive time.Duration
+	}{
+		"defaults": {
+			expTimeout:   30 * time.Second,
+			expKeepAlive: 30 * time.Second,
+		},
+		"configured": {
+			opts:         ClientOptions{DialTimeout: 2 * time.Second, KeepAlive: 5 * time.Minute},
+			expTimeout:   2 * time.Second,
+			expKeepAlive: 5 * time.Minute,
+		},
+		"keep-alives disabled": {
+			opts:         ClientOptions{KeepAlive: -1},
+			expTimeout:   30 * time.Second,
+			expKeepAlive: -1,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			d := c.opts.dialer()
+			require.Equal(t, c.expTimeout, d.Timeout)
+			require.Equal(t, c.expKeepAlive, d.KeepAlive)
+		})
+	}
+}
+
+func TestValidateServerName(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		addr       string
+		serverName string
+		expErr     string
+	}{
+		"no server name":          {addr: "127.0.0.1:8501"},
+		"remote address":          {addr: "https://consul-server.consul:8501", serverName: "server.dc1.consul"},
+		"remote ip":               {addr: "10.0.0.5:8501", serverName: "server.dc1.consul"},
+		"server name matches":     {addr: "https://localhost:8501", serverName: "localhost"},
+		"server name matches ip":  {addr: "127.0.0.1:8501", serverName: "127.0.0.1"},
+		"loopback ip":             {addr: "127.0.0.1:8501", serverName: "server.dc1.consul", expErr: `-tls-server-name "server.dc1.consul" is set but -http-addr "127.0.0.1:8501" is a loopback address whose certificate won't match it`},
+		"loopback ip with scheme": {addr: "https://127.0.0.1:8501", serverName: "server.dc1.consul", expErr: `-tls-server-name "server.dc1.consul" is set but -http-addr "https://127.0.0.1:8501" is a loopback address whose certificate won't match it`},
+		"localhost":               {addr: "localhost:8501", serverName: "server.dc1.consul", expErr: `-tls-server-name "server.dc1.consul" is set but -http-addr "localhost:8501" is a loopback address whose certificate won't match it`},
+		"ipv6 loopback":           {addr: "https://[::1]:8501", serverName: "server.dc1.consul", expErr: `-tls-server-name "server.dc1.consul" is set but -http-addr "https://[::1]:8501" is a loopback address whose certificate won't match it`},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateServerName(c.addr, c.serverName)
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	ch := clock.After(time.Minute)
+	require.Equal(t, 1, clock.Waiters())
+
+	clock.Advance(59 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("fired too early")
+	default:
+	}
+	clock.Advance(time.Second)
+	require.Equal(t, start.Add(time.Minute), <-ch)
+	require.Equal(t, start.Add(time.Minute), clock.Now())
+	require.Zero(t, clock.Waiters())
+}
+
+// TestRunLoginLoop_FakeClock drives a login loop with a fake clock to check
+// that failed logins are retried after the backoff and successful ones after
+// the interval.
+func TestRunLoginLoop_FakeClock(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	client := startFailingLoginServer(t, &logins, 2)
+
+	clock := NewFakeClock(time.Now())
+	history := NewLoginErrorHistory(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cfg := testLoginConfig(t, client)
+		cfg.Backoff = NewBackoff(backoff.NewConstantBackOff(5*time.Second), 1)
+		cfg.Clock = clock
+		cfg.ErrorHistory = history
+		RunLoginLoop(ctx, cfg, time.Hour)
+	}()
+
+	// waitForLogins waits until the loop has made n logins and is waiting
+	// for the clock.
+	waitForLogins := func(n int32) {
+		require.Eventually(t, func() bool {
+			return clock.Waiters() == 1 && atomic.LoadInt32(&logins) == n
+		}, 5*time.Second, time.Millisecond)
+	}
+
+	waitForLogins(1)
+	clock.Advance(5 * time.Second)
+	waitForLogins(2)
+	clock.Advance(5 * time.Second)
+	waitForLogins(3)
+	// The third login succeeded so the next one is only after the interval.
+	clock.Advance(5 * time.Second)
+	waitForLogins(3)
+	clock.Advance(time.Hour + time.Hour/10)
+	waitForLogins(4)
+
+	cancel()
+	<-done
+	require.Len(t, history.Entries(), 2)
+}
+
+// TestRunLoginLoop_LogsRetryProgress ensures that each retry logs its
+// attempt number, the time elapsed since the first failure and the wait.
+func TestRunLoginLoop_LogsRetryProgress(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	client := startFailingLoginServer(t, &logins, 3)
+
+	var logs bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := &recordingClock{FakeClock: NewFakeClock(time.Now()), max: 4, done: cancel}
+	cfg := testLoginConfig(t, client)
+	cfg.Backoff = NewBackoff(backoff.NewConstantBackOff(5*time.Second), 1)
+	cfg.Clock = clock
+	cfg.Logger = hclog.New(&hclog.LoggerOptions{Output: &logs})
+	RunLoginLoop(ctx, cfg, time.Hour)
+
+	out := logs.String()
+	first := strings.Index(out, "Retrying Consul login: attempt=1 elapsed=0s next-retry-in=5s")
+	second := strings.Index(out, "Retrying Consul login: attempt=2 elapsed=5s next-retry-in=5s")
+	third := strings.Index(out, "Retrying Consul login: attempt=3 elapsed=10s next-retry-in=5s")
+	require.True(t, first >= 0 && second > first && third > second, out)
+	// The successful login ends the retries.
+	require.Equal(t, 3, strings.Count(out, "Retrying Consul login"))
+}
+
+// TestLoginWithRetry_MaxTotalDuration ensures that retries stop once the
+// budget is used up and that the last error is returned.
+func TestLoginWithRetry_MaxTotalDuration(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	client := startFailingLoginServer(t, &logins, -1)
+
+	clock := NewFakeClock(time.Now())
+	done := make(chan error)
+	go func() {
+		cfg := testLoginConfig(t, client)
+		cfg.MaxTotalDuration = 10 * time.Second
+		cfg.Clock = clock
+		_, err := LoginWithRetry(context.Background(), cfg, 4*time.Second)
+		done <- err
+	}()
+
+	waitForLogins := func(n int32) {
+		require.Eventually(t, func() bool {
+			return clock.Waiters() == 1 && atomic.LoadInt32(&logins) == n
+		}, 5*time.Second, time.Millisecond)
+	}
+	waitForLogins(1)
+	clock.Advance(4 * time.Second)
+	waitForLogins(2)
+	clock.Advance(4 * time.Second)
+	waitForLogins(3)
+	// Only 2s of the budget are left so the last wait is cut short.
+	clock.Advance(2 * time.Second)
+
+	err := <-done
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "gave up after 10s: error logging in: Unexpected response code: 500 (boom)"), err.Error())
+	var diagnosed *DiagnosedError
+	require.True(t, errors.As(err, &diagnosed))
+	require.Equal(t, int32(4), atomic.LoadInt32(&logins))
+	require.Zero(t, clock.Waiters())
+}
+
+// TestLoginWithRetry_ShouldRetry ensures that a custom retry predicate
+// overrides the default classification and can stop retrying.
+func TestLoginWithRetry_ShouldRetry(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	client := startFailingLoginServer(t, &logins, -1)
+
+	var attempts []int
+	clock := NewFakeClock(time.Now())
+	cfg := testLoginConfig(t, client)
+	cfg.ShouldRetry = func(err error, attempt int) bool {
+		attempts = append(attempts, attempt)
+		return attempt < 1
+	}
+	cfg.Clock = clock
+	_, err := LoginWithRetry(context.Background(), cfg, time.Second)
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "error logging in: Unexpected response code: 500 (boom)"), err.Error())
+	require.Equal(t, []int{1}, attempts)
+	require.Equal(t, int32(1), atomic.LoadInt32(&logins))
+	require.Zero(t, clock.Waiters())
+}
+
+// TestLoginWithRetry_ShouldRetryWithBackoff ensures that the predicate is
+// called once per failure when its result also decides whether to back off.
+func TestLoginWithRetry_ShouldRetryWithBackoff(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	client := startFailingLoginServer(t, &logins, -1)
+
+	exp := backoff.ExponentialBackOff{
+		InitialInterval: time.Second,
+		Multiplier:      3,
+		MaxInterval:     time.Minute,
+		Clock:           backoff.SystemClock,
+	}
+	exp.Reset()
+	var attempts []int
+	clock := &recordingClock{FakeClock: NewFakeClock(time.Now()), max: -1}
+	cfg := testLoginConfig(t, client)
+	cfg.Backoff = NewBackoff(&exp, 1)
+	cfg.ShouldRetry = func(err error, attempt int) bool {
+		attempts = append(attempts, attempt)
+		return attempt < 3
+	}
+	cfg.Clock = clock
+	_, err := LoginWithRetry(context.Background(), cfg, time.Hour)
+	require.Error(t, err)
+	require.Equal(t, []int{1, 2, 3}, attempts)
+	require.Equal(t, int32(3), atomic.LoadInt32(&logins))
+	// Both retries backed off instead of waiting the interval.
+	require.Equal(t, []time.Duration{time.Second, 3 * time.Second}, clock.waits)
+}
+
+// TestConsulLogin_InitialDelay ensures that no login happens until the
+// initial delay has passed.
+func TestConsulLogin_InitialDelay(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/acl/login" {
+			atomic.AddInt32(&logins, 1)
+		}
+		w.Write([]byte(testLoginResponse))
+	})
+
+	clock := NewFakeClock(time.Now())
+	done := make(chan error)
+	go func() {
+		cfg := testLoginConfig(t, client)
+		cfg.InitialDelay = 10 * time.Second
+		cfg.Clock = clock
+		_, err := ConsulLogin(cfg)
+		done <- err
+	}()
+
+	require.Eventually(t, func() bool { return clock.Waiters() == 1 }, 5*time.Second, time.Millisecond)
+	clock.Advance(9 * time.Second)
+	require.Equal(t, 1, clock.Waiters())
+	require.Zero(t, atomic.LoadInt32(&logins))
+
+	clock.Advance(time.Second)
+	require.NoError(t, <-done)
+	require.Equal(t, int32(1), atomic.LoadInt32(&logins))
+}
@@ -2,15 +2,26 @@
 package common
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/cenkalti/backoff"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
 )
 
 const (
@@ -22,17 +33,47 @@ const (
 	// ACLTokenSecretKey is the key that we store the ACL tokens in when we
 	// create Kubernetes secrets.
 	ACLTokenSecretKey = "token"
+
+	// DefaultBearerTokenFile is where Kubernetes mounts the service account
+	// token in every pod.
+	DefaultBearerTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// DefaultMaxTokenBytes is the largest bearer token file read when
+	// LoginConfig.MaxTokenBytes isn't set. Service account tokens are a few
+	// kilobytes so this leaves plenty of headroom.
+	DefaultMaxTokenBytes = 1 << 20
+
+	// DefaultMaxLogBytes is how much of the login response is logged when
+	// LoginConfig.MaxLogBytes isn't set.
+	DefaultMaxLogBytes = 1024
+
+	// tokenDigestSuffix is appended to the token sink file name to get the
+	// name of the file its SHA-256 digest is written to.
+	tokenDigestSuffix = ".sha256"
 )
 
 // Logger returns an hclog instance or an error if level is invalid.
 func Logger(level string) (hclog.Logger, error) {
+	return LoggerWithQuiet(level, false)
+}
+
+// LoggerWithQuiet is like Logger but if quiet is true only errors are logged,
+// regardless of level. level is still validated.
+func LoggerWithQuiet(level string, quiet bool) (hclog.Logger, error) {
+	return newLogger(level, quiet, os.Stderr)
+}
+
+func newLogger(level string, quiet bool, out io.Writer) (hclog.Logger, error) {
 	parsedLevel := hclog.LevelFromString(level)
 	if parsedLevel == hclog.NoLevel {
 		return nil, fmt.Errorf("unknown log level: %s", level)
 	}
+	if quiet {
+		parsedLevel = hclog.Error
+	}
 	return hclog.New(&hclog.LoggerOptions{
 		Level:  parsedLevel,
-		Output: os.Stderr,
+		Output: out,
 	}), nil
 }
 
@@ -50,48 +91,828 @@ func ValidateUnprivilegedPort(flagName, flagValue string) error {
 	return nil
 }
 
-// ConsulLogin issues an ACL().Login to Consul and writes out the token to tokenSinkFile.
+// ValidateTLSFlags checks that the -http-addr, -ca-file and -tls-server-name
+// flag values are consistent with each other. An https address needs at least
+// one of a CA file or TLS server name, and a CA file is of no use with an
+// explicit http address.
+func ValidateTLSFlags(addr, caFile, serverName string) error {
+	switch {
+	case strings.HasPrefix(addr, "https://") && caFile == "" && serverName == "":
+		return fmt.Errorf("-http-addr %s uses https but neither -ca-file nor -tls-server-name is set; "+
+			"set -ca-file to the Consul CA certificate to verify the server", addr)
+	case strings.HasPrefix(addr, "http://") && caFile != "":
+		return fmt.Errorf("-ca-file is set but -http-addr %s uses http; "+
+			"use an https address to communicate with Consul over TLS", addr)
+	}
+	return nil
+}
+
+// LoginConfig holds the parameters needed to log in to Consul via an auth
+// method and write the resulting ACL token to disk.
+type LoginConfig struct {
+	// Client is the Consul client used to make the login request.
+	Client *api.Client
+	// FailoverClients are tried in order if Client, or the previous failover
+	// client, can't connect to Consul. See ConsulClientFromAddrs.
+	FailoverClients []*api.Client
+	// HTTPAddr is the address of the Consul server Client talks to. It is
+	// only used to describe the login, for example by ReproCommand, since the
+	// client doesn't expose its address.
+	HTTPAddr string
+	// Preflight, if true, checks that Consul is reachable with PingConsul
+	// before doing anything else so that connectivity problems are reported
+	// clearly rather than as a login failure.
+	Preflight bool
+	// BearerTokenFile is the path to the bearer token passed to the auth method,
+	// usually the Kubernetes service account token.
+	BearerTokenFile string
+	// BearerTokenFiles are fallback paths tried in order if BearerTokenFile
+	// can't be read or is empty, for example while a projected token is being
+	// remounted. The first readable non-empty file is used.
+	BearerTokenFiles []string
+	// BearerTokenBase64, if true, means the bearer token files hold the
+	// bearer token base64 encoded, as delivered by some secret stores.
+	BearerTokenBase64 bool
+	// MaxTokenBytes is the largest bearer token file that is read. Larger
+	// files are an error rather than being read into memory. If zero,
+	// DefaultMaxTokenBytes is used.
+	MaxTokenBytes int64
+	// AuthMethod is the name of the auth method to log in with.
+	AuthMethod string
+	// AuthMethodFile is the path to a file containing the name of the auth
+	// method to log in with. It is only read if AuthMethod is not set.
+	AuthMethodFile string
+	// RequiredAuthMethodType, if set, is the type the auth method must have,
+	// such as "kubernetes". It is checked before logging in. See
+	// RequireAuthMethodType.
+	RequiredAuthMethodType string
+	// TokenSinkFile is the path the ACL token is written to.
+	TokenSinkFile string
+	// SinkFilePrefix and SinkFileSuffix, if set, are added around the file
+	// name of TokenSinkFile, for example to include the hostname. See
+	// DecorateSinkName.
+	SinkFilePrefix string
+	SinkFileSuffix string
+	// TokenSinkDir, if set, is used instead of TokenSinkFile. Each login
+	// writes the token to a new timestamped file in this directory and points
+	// a "current" symlink at it. See WriteTokenToDir.
+	TokenSinkDir string
+	// TokenSink, if set, is where the ACL token is written instead of
+	// TokenSinkFile or TokenSinkDir, such as a VaultSink. The settings that
+	// only make sense for files can't be used with it.
+	TokenSink TokenSink
+	// ForbidTokenOverwrite, if true, makes ConsulLogin fail rather than
+	// replace a different non-empty token already in the sink, for strict
+	// environments where a token changing unexpectedly must be investigated.
+	ForbidTokenOverwrite bool
+	// SinkPathPrefix, if set, enables strict mode where the token sink must be
+	// under this directory. This is checked before logging in.
+	SinkPathPrefix string
+	// ForbidHostPathSink, if true, enables strict mode where the token sink
+	// must not be on a directory mounted from the host, such as a hostPath
+	// volume. This is checked before logging in. See AssertNotHostPath.
+	ForbidHostPathSink bool
+	// TokenEncryptionKeyEnv, if set, is the name of an environment variable
+	// holding a base64 encoded AES key. The token is then encrypted with it
+	// before being written to TokenSinkFile. See ReadEncryptedToken.
+	TokenEncryptionKeyEnv string
+	// WriteTokenDigest, if true, also writes the hex encoded SHA-256 digest of
+	// the token to TokenSinkFile + ".sha256" so consumers can verify it.
+	WriteTokenDigest bool
+	// ConfirmSinkWrite, if true, makes ConsulLogin wait until the token it
+	// wrote to the file sink is observable before returning. See
+	// ConfirmWrite.
+	ConfirmSinkWrite bool
+	// ResponseSinkFile, if set, is the path the full LoginResponse is also
+	// written to as JSON, for consumers that need the accessor ID or
+	// expiration along with the token. If TokenEncryptionKeyEnv is set, its
+	// SecretID is encrypted like the token sink.
+	ResponseSinkFile string
+	// Namespace is the Consul namespace the auth method is defined in.
+	Namespace string
+	// Meta is the metadata to attach to the token created by the login.
+	Meta map[string]string
+	// RequiredServiceIdentity, if set, is the name of a service identity the
+	// token must have. It guards against binding rule misconfigurations that
+	// would otherwise hand out a token for the wrong service.
+	RequiredServiceIdentity string
+	// SkipDiagnostics, if true, makes ConsulLogin return failed logins
+	// without running DiagnoseLoginFailure. Callers that retry set it so
+	// that every attempt doesn't make more requests to a failing Consul and
+	// diagnose only their final error.
+	SkipDiagnostics bool
+	// Backoff, if set, is used by RunLoginLoop to decide how long to wait
+	// after a failed login instead of waiting for the next interval.
+	Backoff *Backoff
+	// LockFile, if set, is locked by RunLoginLoop for as long as it runs so
+	// that only one login loop runs at a time. See AcquireFileLock.
+	LockFile string
+	// PIDFile, if set, is the path RunLoginLoop writes its process's PID to
+	// for as long as it runs. See WritePIDFile.
+	PIDFile string
+	// Metrics, if set, records the duration and outcome of each login.
+	Metrics *LoginMetrics
+	// ErrorHistory, if set, records the errors of the logins made by
+	// RunLoginLoop.
+	ErrorHistory *LoginErrorHistory
+	// AuditFile, if set, is the path of a file that a JSON AuditRecord is
+	// appended to for every login attempt. See AppendAuditRecord.
+	AuditFile string
+	// Logger is used to log warnings and errors that don't cause the login to
+	// fail, such as in RunLoginLoop. If nil, nothing is logged.
+	Logger hclog.Logger
+	// MaxLogBytes is how many bytes of the login response, with its
+	// SecretID redacted, are logged at debug level. If zero,
+	// DefaultMaxLogBytes is used. If negative, it is logged in full.
+	MaxLogBytes int
+	// InitialDelay, if set, is waited before the first login attempt to let
+	// sidecars and CSI mounts settle. ConsulLogin waits it on every call,
+	// RunLoginLoop only before its first login.
+	InitialDelay time.Duration
+	// MaxTotalDuration, if set, bounds how long LoginWithRetry keeps
+	// retrying, including the time spent in each attempt. It is distinct from
+	// any per-attempt timeout.
+	MaxTotalDuration time.Duration
+	// ShouldRetry, if set, decides whether a failed login is worth retrying
+	// instead of ClassifyError. attempt is the number of consecutive failed
+	// logins, starting at one. If it returns false, LoginWithRetry returns
+	// the error and RunLoginLoop waits for the next interval rather than
+	// backing off.
+	ShouldRetry func(err error, attempt int) bool
+	// Clock is used for all time based behavior, such as RunLoginLoop's
+	// waits. If nil, RealClock is used.
+	Clock Clock
+
+	// fsType is used in tests to fake the filesystem type of the token sink.
+	fsType func(string) (int64, error)
+	// mountInfo is used in tests to fake the contents of
+	// /proc/self/mountinfo.
+	mountInfo func() ([]byte, error)
+	// defaultBearerTokenFile is used in tests instead of
+	// DefaultBearerTokenFile.
+	defaultBearerTokenFile string
+}
+
+// Validate checks that cfg has everything needed to log in: a client, an
+// auth method, a bearer token and a token sink. All missing settings are
+// reported in a single error.
+func (cfg LoginConfig) Validate() error {
+	var missing []string
+	if cfg.Client == nil {
+		missing = append(missing, "Client")
+	}
+	if cfg.AuthMethod == "" && cfg.AuthMethodFile == "" {
+		missing = append(missing, "AuthMethod or AuthMethodFile")
+	}
+	if cfg.BearerTokenFile == "" && len(cfg.BearerTokenFiles) == 0 {
+		missing = append(missing, "BearerTokenFile or BearerTokenFiles")
+	}
+	if cfg.TokenSinkFile == "" && cfg.TokenSinkDir == "" && cfg.TokenSink == nil {
+		missing = append(missing, "TokenSinkFile, TokenSinkDir or TokenSink")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("login config is missing required settings: %s", strings.Join(missing, ", "))
+	}
+	if cfg.TokenSink != nil {
+		var conflicting []string
+		if cfg.TokenSinkFile != "" {
+			conflicting = append(conflicting, "TokenSinkFile")
+		}
+		if cfg.TokenSinkDir != "" {
+			conflicting = append(conflicting, "TokenSinkDir")
+		}
+		if cfg.ForbidTokenOverwrite {
+			conflicting = append(conflicting, "ForbidTokenOverwrite")
+		}
+		if cfg.WriteTokenDigest {
+			conflicting = append(conflicting, "WriteTokenDigest")
+		}
+		if cfg.ConfirmSinkWrite {
+			conflicting = append(conflicting, "ConfirmSinkWrite")
+		}
+		if cfg.SinkPathPrefix != "" {
+			conflicting = append(conflicting, "SinkPathPrefix")
+		}
+		if cfg.ForbidHostPathSink {
+			conflicting = append(conflicting, "ForbidHostPathSink")
+		}
+		if len(conflicting) > 0 {
+			return fmt.Errorf("TokenSink cannot be used with: %s", strings.Join(conflicting, ", "))
+		}
+	}
+	return nil
+}
+
+// SinkPath returns the path consumers, such as a Consul client's TokenFile,
+// read the token from. It is empty if the token is written to a TokenSink.
+func (cfg LoginConfig) SinkPath() string {
+	if cfg.TokenSinkDir != "" {
+		return filepath.Join(cfg.TokenSinkDir, currentTokenLink)
+	}
+	if cfg.TokenSinkFile == "" {
+		return ""
+	}
+	return DecorateSinkName(cfg.TokenSinkFile, cfg.SinkFilePrefix, cfg.SinkFileSuffix)
+}
+
+// clock returns cfg.Clock, or RealClock if unset.
+func (cfg LoginConfig) clock() Clock {
+	if cfg.Clock == nil {
+		return RealClock{}
+	}
+	return cfg.Clock
+}
+
+// maxLogBytes returns the maximum number of bytes of the login response to
+// log, or zero if there is none.
+func (cfg LoginConfig) maxLogBytes() int {
+	switch {
+	case cfg.MaxLogBytes == 0:
+		return DefaultMaxLogBytes
+	case cfg.MaxLogBytes < 0:
+		return 0
+	}
+	return cfg.MaxLogBytes
+}
+
+// logger returns cfg.Logger, or a logger that discards everything if unset.
+func (cfg LoginConfig) logger() hclog.Logger {
+	if cfg.Logger == nil {
+		return hclog.NewNullLogger()
+	}
+	return cfg.Logger
+}
+
+// ConsulLogin issues an ACL().Login to Consul and writes out the token to cfg.TokenSinkFile.
+// It returns the token created by the login.
+// Each call is given a request ID that is included in cfg.Logger's lines and,
+// for clients built by this package, sent to Consul. See WithRequestID.
 // The logic of this is taken from the `consul login` command.
-func ConsulLogin(client *api.Client, bearerTokenFile, authMethodName, tokenSinkFile, namespace string, meta map[string]string) error {
-	if meta == nil {
-		return fmt.Errorf("invalid meta")
+func ConsulLogin(cfg LoginConfig) (*LoginResponse, error) {
+	return consulLogin(context.Background(), cfg)
+}
+
+// consulLogin is ConsulLogin making the login request and writing the token
+// to a ContextTokenSink with ctx, so that loops can cancel them.
+func consulLogin(ctx context.Context, cfg LoginConfig) (resp *LoginResponse, err error) {
+	if cfg.InitialDelay > 0 {
+		<-cfg.clock().After(cfg.InitialDelay)
 	}
-	data, err := ioutil.ReadFile(bearerTokenFile)
+	logger, requestID := WithRequestID(cfg.logger())
+	cfg.Logger = logger
+	start := cfg.clock().Now()
+	defer func() {
+		cfg.Metrics.observe(cfg.clock().Now().Sub(start), err)
+		audit(cfg, start, resp, err)
+	}()
+
+	// Validate before anything that uses the client, such as Preflight.
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ValidateNamespace(cfg.Namespace); err != nil {
+		return nil, err
+	}
+	if cfg.Meta == nil {
+		return nil, fmt.Errorf("invalid meta")
+	}
+	if cfg.SinkPathPrefix != "" {
+		if err := AssertSinkUnderPrefix(cfg.SinkPath(), cfg.SinkPathPrefix); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ForbidHostPathSink {
+		mountInfo := cfg.mountInfo
+		if mountInfo == nil {
+			mountInfo = readMountInfo
+		}
+		ok, err := assertNotHostPath(cfg.SinkPath(), mountInfo)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check token sink %s: %s", cfg.SinkPath(), err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("token sink %s is on a volume mounted from the host", cfg.SinkPath())
+		}
+	}
+	if cfg.Preflight {
+		if err := preflight(cfg); err != nil {
+			return nil, err
+		}
+	}
+	bearerToken, err := readBearerToken(cfg)
 	if err != nil {
-		return fmt.Errorf("unable to read bearerTokenFile: %v, err: %v", bearerTokenFile, err)
+		return nil, err
 	}
-	bearerToken := strings.TrimSpace(string(data))
-	if bearerToken == "" {
-		return fmt.Errorf("no bearer token found in %s", bearerTokenFile)
+	if cfg.AuthMethod == "" && cfg.AuthMethodFile != "" {
+		data, err := ioutil.ReadFile(cfg.AuthMethodFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read authMethodFile: %v, err: %v", cfg.AuthMethodFile, err)
+		}
+		cfg.AuthMethod = strings.TrimSpace(string(data))
+		if cfg.AuthMethod == "" {
+			return nil, fmt.Errorf("no auth method found in %s", cfg.AuthMethodFile)
+		}
+	}
+	if cfg.TokenSink == nil {
+		if err := checkSinkWritable(cfg.SinkPath()); err != nil {
+			return nil, fmt.Errorf("error writing token to file sink: %v", err)
+		}
+	}
+	if cfg.RequiredAuthMethodType != "" {
+		if err := requireAuthMethodType(cfg.Client, cfg.AuthMethod, cfg.Namespace, cfg.RequiredAuthMethodType); err != nil {
+			return nil, err
+		}
 	}
+	var encryptionKey []byte
+	if cfg.TokenEncryptionKeyEnv != "" {
+		if encryptionKey, err = tokenEncryptionKey(cfg.TokenEncryptionKeyEnv); err != nil {
+			return nil, err
+		}
+	}
+	warnIfTokenReused(cfg, bearerToken)
 	// Do the login.
+	logger.Debug("Logging in to Consul", "auth-method", cfg.AuthMethod)
 	req := &api.ACLLoginParams{
-		AuthMethod:  authMethodName,
+		AuthMethod:  cfg.AuthMethod,
 		BearerToken: bearerToken,
-		Meta:        meta,
+		Meta:        cfg.Meta,
+	}
+	var tok *api.ACLToken
+	var wait time.Duration
+	client := cfg.Client
+	for i, c := range append([]*api.Client{cfg.Client}, cfg.FailoverClients...) {
+		client = c
+		ctx := contextWithRequestID(ctx, requestID)
+		ctx = contextWithRetryAfter(ctx, &wait, cfg.clock())
+		opts := (&api.WriteOptions{Namespace: cfg.Namespace}).WithContext(ctx)
+		tok, _, err = client.ACL().Login(req, opts)
+		if !isConnectionErr(err) || i == len(cfg.FailoverClients) {
+			break
+		}
+		cfg.logger().Warn("Unable to connect to Consul; trying next address", "error", err)
+	}
+	if isACLDisabledErr(err) {
+		return nil, fmt.Errorf("unable to log in with auth method %q: ACLs are not enabled on the Consul servers", cfg.AuthMethod)
+	}
+	if err != nil && wait > 0 {
+		// Diagnosing the failure would only make more requests to a Consul
+		// that is already overloaded.
+		return nil, &RateLimitError{RetryAfter: wait, Err: fmt.Errorf("error logging in: %s", err)}
+	}
+	if err != nil && cfg.SkipDiagnostics {
+		return nil, fmt.Errorf("error logging in: %w", err)
 	}
-	tok, _, err := client.ACL().Login(req, &api.WriteOptions{Namespace: namespace})
 	if err != nil {
-		return fmt.Errorf("error logging in: %s", err)
+		return nil, &DiagnosedError{
+			Err:         fmt.Errorf("error logging in: %w", err),
+			Diagnostics: DiagnoseLoginFailure(client, cfg),
+		}
+	}
+
+	if err := validateSecretID(tok.SecretID); err != nil {
+		return nil, err
+	}
+	if cfg.RequiredServiceIdentity != "" && !hasServiceIdentity(tok, cfg.RequiredServiceIdentity) {
+		return nil, fmt.Errorf("token from auth method %q does not have required service identity %q", cfg.AuthMethod, cfg.RequiredServiceIdentity)
+	}
+
+	payload := tok.SecretID
+	if encryptionKey != nil {
+		if payload, err = encryptToken(payload, encryptionKey); err != nil {
+			return nil, fmt.Errorf("error encrypting token: %v", err)
+		}
 	}
 
-	if err := WriteFileWithPerms(tokenSinkFile, tok.SecretID, 0444); err != nil {
-		return fmt.Errorf("error writing token to file sink: %v", err)
+	if cfg.ForbidTokenOverwrite {
+		if err := checkSinkOverwrite(cfg.SinkPath(), tok.SecretID, encryptionKey); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.TokenSink != nil {
+		if err := writeToSink(ctx, cfg.TokenSink, payload); err != nil {
+			return nil, fmt.Errorf("error writing token to sink: %v", err)
+		}
+	} else {
+		warnIfPersistentSink(cfg)
+		writeStart := cfg.clock().Now()
+		if cfg.TokenSinkDir != "" {
+			err = writeTokenToDir(cfg.TokenSinkDir, payload, cfg.clock().Now())
+		} else {
+			err = FileSink{Path: cfg.SinkPath()}.Write(payload)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error writing token to file sink: %v", err)
+		}
+		if cfg.ConfirmSinkWrite && !isFIFO(cfg.SinkPath()) {
+			if err := confirmWrite(cfg.SinkPath(), payload, writeStart, cfg.clock()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.WriteTokenDigest {
+		digest := sha256.Sum256([]byte(tok.SecretID))
+		if err := WriteFileWithPerms(cfg.SinkPath()+tokenDigestSuffix, hex.EncodeToString(digest[:]), 0444); err != nil {
+			return nil, fmt.Errorf("error writing token digest to file sink: %v", err)
+		}
+	}
+	resp = newLoginResponse(tok)
+	if logger.IsDebug() {
+		logged := *resp
+		logged.SecretID = redacted
+		data, _ := json.Marshal(logged)
+		logger.Debug("Logged in to Consul", "response", TruncateForLog(string(data), cfg.maxLogBytes()))
+	}
+	if cfg.ResponseSinkFile != "" {
+		if err := writeResponseSink(cfg.ResponseSinkFile, resp, payload); err != nil {
+			return nil, fmt.Errorf("error writing login response to file sink: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+// readBearerToken returns the contents of the first readable, non-empty file
+// out of cfg.BearerTokenFile and cfg.BearerTokenFiles.
+func readBearerToken(cfg LoginConfig) (string, error) {
+	var paths []string
+	for _, p := range append([]string{cfg.BearerTokenFile}, cfg.BearerTokenFiles...) {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return "", errors.New("no bearer token file configured")
+	}
+
+	defaultPath := cfg.defaultBearerTokenFile
+	if defaultPath == "" {
+		defaultPath = DefaultBearerTokenFile
+	}
+	maxBytes := cfg.MaxTokenBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxTokenBytes
+	}
+	var errs *multierror.Error
+	notInKubernetes := false
+	for _, p := range paths {
+		data, err := readFileLimited(p, maxBytes)
+		if err == errTooLarge {
+			return "", fmt.Errorf("bearer token file %s is larger than %d bytes", p, maxBytes)
+		}
+		if err != nil {
+			// Kubernetes always mounts the default token, so if it's missing
+			// we're most likely being run locally.
+			if p == defaultPath && os.IsNotExist(err) {
+				notInKubernetes = true
+			}
+			errs = multierror.Append(errs, fmt.Errorf("unable to read bearerTokenFile: %v, err: %v", p, err))
+			continue
+		}
+		bearerToken := strings.TrimSpace(string(data))
+		if bearerToken != "" && cfg.BearerTokenBase64 {
+			decoded, err := base64.StdEncoding.DecodeString(bearerToken)
+			if err != nil {
+				return "", fmt.Errorf("bearer token in %s is not valid base64: %s", p, err)
+			}
+			bearerToken = strings.TrimSpace(string(decoded))
+		}
+		if bearerToken != "" {
+			return bearerToken, nil
+		}
+		errs = multierror.Append(errs, fmt.Errorf("no bearer token found in %s", p))
+	}
+	if notInKubernetes {
+		return "", errNotInKubernetes
+	}
+	if len(errs.Errors) == 1 {
+		return "", errs.Errors[0]
+	}
+	return "", errs
+}
+
+// errNotInKubernetes is returned by ConsulLogin if the default service account
+// token doesn't exist, instead of the generic unreadable file error.
+var errNotInKubernetes = errors.New("not running in kubernetes (no service account token found)")
+
+// errTooLarge is returned by readFileLimited if the file is too large.
+var errTooLarge = errors.New("file too large")
+
+// readFileLimited is like ioutil.ReadFile but returns errTooLarge without
+// reading the rest of the file once more than maxBytes have been read.
+func readFileLimited(path string, maxBytes int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
+
+// hasServiceIdentity returns true if tok has a service identity for name.
+func hasServiceIdentity(tok *api.ACLToken, name string) bool {
+	for _, si := range tok.ServiceIdentities {
+		if si != nil && si.ServiceName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSinkOverwrite returns an error if the sink at path already holds a
+// non-empty token other than secretID. key is the key the sink is encrypted
+// with, if any.
+func checkSinkOverwrite(path, secretID string, key []byte) error {
+	if isFIFO(path) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read existing token in %s: %s", path, err)
+	}
+	existing := strings.TrimSpace(string(data))
+	if existing == "" {
+		return nil
+	}
+	if key != nil {
+		if existing, err = ReadEncryptedToken(path, key); err != nil {
+			return err
+		}
+	}
+	if existing != secretID {
+		return fmt.Errorf("token sink %s already contains a different token and overwriting it is forbidden", path)
 	}
 	return nil
 }
 
+// warnIfPersistentSink logs a warning if the token sink's directory is not on
+// an in-memory filesystem, since the token could then outlive the pod.
+func warnIfPersistentSink(cfg LoginConfig) {
+	fsType := cfg.fsType
+	if fsType == nil {
+		fsType = statfsType
+	}
+	sinkDir := filepath.Dir(cfg.SinkPath())
+	ephemeral, err := isEphemeralMount(sinkDir, fsType)
+	if err != nil {
+		cfg.logger().Debug("Unable to check if token sink is ephemeral", "error", err)
+		return
+	}
+	if !ephemeral {
+		// Only warn once per sink since RunLoginLoop writes it on every login.
+		WarnOnce(cfg.logger(), "persistent-sink:"+cfg.SinkPath(),
+			"ACL token is being written to a persistent volume; consider using an in-memory emptyDir", "path", cfg.SinkPath())
+	}
+}
+
+// RunLoginLoop calls ConsulLogin every interval, plus up to 10% jitter, until
+// ctx is cancelled. The first login happens after cfg.InitialDelay, which
+// defaults to none. Failures are logged and retried on the next interval
+// rather than ending the loop, or sooner according to cfg.Backoff unless
+// cfg.ShouldRetry or, by default, ClassifyError says they are permanent. Rate limited logins are retried
+// after the delay Consul asked for; see RateLimitError. If cfg.LockFile is set
+// and can't be locked, RunLoginLoop logs an error and returns without logging
+// in.
+func RunLoginLoop(ctx context.Context, cfg LoginConfig, every time.Duration) {
+	runLoginLoop(ctx, cfg, every)
+}
+
+// runLoginLoop is RunLoginLoop but returns the SecretID of the last
+// successful login, or an empty string if there was none, so that it can be
+// logged out without reading it back from the token sink.
+func runLoginLoop(ctx context.Context, cfg LoginConfig, every time.Duration) (secretID string) {
+	logger := cfg.logger()
+	if cfg.LockFile != "" {
+		release, err := AcquireFileLock(cfg.LockFile)
+		if err != nil {
+			logger.Error("Not starting login loop; another one may be running", "error", err)
+			return
+		}
+		defer release()
+	}
+	if cfg.PIDFile != "" {
+		cleanup, err := WritePIDFile(cfg.PIDFile)
+		if err != nil {
+			logger.Error("Not starting login loop", "error", err)
+			return
+		}
+		defer cleanup()
+	}
+	if cfg.InitialDelay > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.clock().After(cfg.InitialDelay):
+		}
+		cfg.InitialDelay = 0
+	}
+	// attempt counts the consecutive failed logins, which started at
+	// failingSince.
+	var attempt int
+	var failingSince time.Time
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		wait := withJitter(every)
+		started := cfg.clock().Now()
+		if resp, err := consulLogin(ctx, cfg); err != nil {
+			if attempt++; attempt == 1 {
+				failingSince = started
+			}
+			cfg.ErrorHistory.Add(cfg.clock().Now(), err)
+			class := ClassifyError(err)
+			logger.Error("Consul login failed; will retry", "error", err, "class", class.String())
+			// Retrying a permanent error sooner won't help, so only back off
+			// for the others.
+			if d, ok := retryAfter(err); ok {
+				wait = d
+			} else if cfg.Backoff != nil && cfg.shouldRetry(err, attempt) {
+				if next := cfg.Backoff.NextBackOff(); next != backoff.Stop {
+					wait = next
+				}
+			}
+			logRetry(logger, attempt, cfg.clock().Now().Sub(failingSince), wait)
+		} else {
+			secretID = resp.SecretID
+			attempt = 0
+			if cfg.Backoff != nil {
+				cfg.Backoff.Success()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.clock().After(wait):
+		}
+	}
+}
+
+// LoginWithRetry calls ConsulLogin until it succeeds, waiting every between
+// attempts or less according to cfg.Backoff unless ClassifyError says the
+// error is permanent, or the delay Consul asked for if it rate limited the
+// login. It gives up and returns the last error when ctx is
+// cancelled, when cfg.ShouldRetry is set and returns false or, if
+// cfg.MaxTotalDuration is set, once that much time has passed since the first
+// attempt.
+func LoginWithRetry(ctx context.Context, cfg LoginConfig, every time.Duration) (*LoginResponse, error) {
+	logger := cfg.logger()
+	clock := cfg.clock()
+	start := clock.Now()
+	for attempt := 1; ; attempt++ {
+		resp, err := consulLogin(ctx, cfg)
+		if err == nil {
+			if cfg.Backoff != nil {
+				cfg.Backoff.Success()
+			}
+			return resp, nil
+		}
+		// ConsulLogin waits InitialDelay on every call but it should only be
+		// waited before the first attempt.
+		cfg.InitialDelay = 0
+		cfg.ErrorHistory.Add(clock.Now(), err)
+		// Call ShouldRetry only once per failure in case it has state.
+		retry := cfg.shouldRetry(err, attempt)
+		if cfg.ShouldRetry != nil && !retry {
+			return nil, err
+		}
+		class := ClassifyError(err)
+		wait := every
+		if d, ok := retryAfter(err); ok {
+			wait = d
+		} else if cfg.Backoff != nil && retry {
+			if next := cfg.Backoff.NextBackOff(); next != backoff.Stop {
+				wait = next
+			}
+		}
+		if cfg.MaxTotalDuration > 0 {
+			remaining := cfg.MaxTotalDuration - clock.Now().Sub(start)
+			if remaining <= 0 {
+				return nil, fmt.Errorf("gave up after %s: %w", cfg.MaxTotalDuration, err)
+			}
+			if wait > remaining {
+				wait = remaining
+			}
+		}
+		logger.Error("Consul login failed; will retry", "error", err, "class", class.String())
+		logRetry(logger, attempt, clock.Now().Sub(start), wait)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-clock.After(wait):
+		}
+	}
+}
+
+// shouldRetry returns cfg.ShouldRetry(err, attempt) if it is set and
+// otherwise whether ClassifyError says err isn't permanent.
+func (cfg LoginConfig) shouldRetry(err error, attempt int) bool {
+	if cfg.ShouldRetry != nil {
+		return cfg.ShouldRetry(err, attempt)
+	}
+	return ClassifyError(err) != ErrorClassPermanent
+}
+
+// logRetry logs the progress of retrying failed logins so that operators can
+// follow it during outages. attempt is the number of consecutive failed
+// logins and elapsed the time since the first of them started.
+func logRetry(logger hclog.Logger, attempt int, elapsed, wait time.Duration) {
+	logger.Info("Retrying Consul login", "attempt", attempt, "elapsed", elapsed, "next-retry-in", wait)
+}
+
+// withJitter returns d plus a random duration of up to 10% of d.
+func withJitter(d time.Duration) time.Duration {
+	max := int64(d) / 10
+	if max <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(max))
+}
+
+// WriteOption configures WriteFileWithPerms.
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	// fsync is true if the file and its directory should be synced.
+	fsync bool
+	// sync syncs f to disk. It is used in tests to check that syncing happens.
+	sync func(f *os.File) error
+	// write writes b to f. It is used in tests to fake short writes.
+	write func(f *os.File, b []byte) (int, error)
+}
+
+// WithFsync makes WriteFileWithPerms fsync the file and its parent directory
+// before returning so that the write survives a power loss.
+func WithFsync() WriteOption {
+	return func(o *writeOptions) { o.fsync = true }
+}
+
 // WriteFileWithPerms will write payload as the contents of the outputFile and set permissions after writing the contents. This function is necessary since using ioutil.WriteFile() alone will create the new file with the requested permissions prior to actually writing the file, so you can't set read-only permissions.
-func WriteFileWithPerms(outputFile, payload string, mode os.FileMode) error {
-	// os.WriteFile truncates existing files and overwrites them, but only if they are writable.
+func WriteFileWithPerms(outputFile, payload string, mode os.FileMode, opts ...WriteOption) error {
+	o := writeOptions{sync: (*os.File).Sync, write: (*os.File).Write}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// Opening with O_TRUNC truncates existing files and overwrites them, but only if they are writable.
 	// If the file exists it will already likely be read-only. Remove it first.
 	if _, err := os.Stat(outputFile); err == nil {
 		if err = os.Remove(outputFile); err != nil {
 			return fmt.Errorf("unable to delete existing file: %s", err)
 		}
 	}
-	if err := ioutil.WriteFile(outputFile, []byte(payload), os.ModePerm); err != nil {
+	f, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to write file: %s", err)
+	}
+	n, err := o.write(f, []byte(payload))
+	if err == nil && n != len(payload) {
+		err = fmt.Errorf("short write of %d out of %d bytes", n, len(payload))
+	}
+	if err != nil {
+		f.Close()
 		return fmt.Errorf("unable to write file: %s", err)
 	}
-	return os.Chmod(outputFile, mode)
+	if o.fsync {
+		if err := o.sync(f); err != nil {
+			f.Close()
+			return fmt.Errorf("unable to sync file: %s", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to write file: %s", err)
+	}
+	// The file was created with a mode filtered by the umask, so set the
+	// requested mode explicitly and check it stuck.
+	if err := os.Chmod(outputFile, mode); err != nil {
+		return err
+	}
+	info, err := os.Stat(outputFile)
+	if err != nil {
+		return fmt.Errorf("unable to verify file mode: %s", err)
+	}
+	if info.Mode().Perm() != mode.Perm() {
+		return fmt.Errorf("file %s has mode %s after setting it to %s", outputFile, info.Mode().Perm(), mode.Perm())
+	}
+	if !o.fsync {
+		return nil
+	}
+	// Sync the directory too, otherwise the new directory entry could still
+	// be lost.
+	dir, err := os.Open(filepath.Dir(outputFile))
+	if err != nil {
+		return fmt.Errorf("unable to sync directory: %s", err)
+	}
+	defer dir.Close()
+	if err := o.sync(dir); err != nil {
+		return fmt.Errorf("unable to sync directory: %s", err)
+	}
+	return nil
 }
@@ -0,0 +1,26 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestWriteFileWithPerms_RestrictiveUmask ensures that the umask doesn't
+// change the requested mode. It changes the process umask so it must not run
+// in parallel with other tests.
+func TestWriteFileWithPerms_RestrictiveUmask(t *testing.T) {
+	old := syscall.Umask(0077)
+	t.Cleanup(func() { syscall.Umask(old) })
+
+	for _, mode := range []os.FileMode{0444, 0644, 0755} {
+		path := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, WriteFileWithPerms(path, "foo", mode))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, mode, info.Mode().Perm())
+	}
+}
@@ -1,6 +1,12 @@
 package common
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -8,6 +14,9 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -27,6 +36,23 @@ func TestLogger(t *testing.T) {
 	require.True(t, lgr.IsDebug())
 }
 
+func TestLoggerWithQuiet(t *testing.T) {
+	var buf bytes.Buffer
+	lgr, err := newLogger("debug", true, &buf)
+	require.NoError(t, err)
+	lgr.Debug("debug line")
+	lgr.Info("info line")
+	lgr.Warn("warn line")
+	lgr.Error("error line")
+	require.NotContains(t, buf.String(), "debug line")
+	require.NotContains(t, buf.String(), "info line")
+	require.NotContains(t, buf.String(), "warn line")
+	require.Contains(t, buf.String(), "error line")
+
+	_, err = LoggerWithQuiet("invalid", true)
+	require.EqualError(t, err, "unknown log level: invalid")
+}
+
 func TestValidateUnprivilegedPort(t *testing.T) {
 	err := ValidateUnprivilegedPort("-test-flag-name", "1234")
 	require.NoError(t, err)
@@ -36,6 +62,50 @@ func TestValidateUnprivilegedPort(t *testing.T) {
 	require.EqualError(t, err, "-test-flag-name value of 22 is not in the unprivileged port range 1024-65535")
 }
 
+func TestValidateTLSFlags(t *testing.T) {
+	cases := map[string]struct {
+		addr       string
+		caFile     string
+		serverName string
+		expErr     string
+	}{
+		"https with CA file": {
+			addr:   "https://consul-server:8501",
+			caFile: "/consul/tls/ca.crt",
+		},
+		"https with server name": {
+			addr:       "https://consul-server:8501",
+			serverName: "server.dc1.consul",
+		},
+		"http without TLS flags": {
+			addr: "http://consul-server:8500",
+		},
+		"address without scheme": {
+			addr:   "consul-server:8501",
+			caFile: "/consul/tls/ca.crt",
+		},
+		"https without CA file or server name": {
+			addr:   "https://consul-server:8501",
+			expErr: "-http-addr https://consul-server:8501 uses https but neither -ca-file nor -tls-server-name is set; set -ca-file to the Consul CA certificate to verify the server",
+		},
+		"http with CA file": {
+			addr:   "http://consul-server:8500",
+			caFile: "/consul/tls/ca.crt",
+			expErr: "-ca-file is set but -http-addr http://consul-server:8500 uses http; use an https address to communicate with Consul over TLS",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateTLSFlags(c.addr, c.caFile, c.serverName)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
 // TestConsulLogin ensures that our implementation of consul login hits `/v1/acl/login`.
 func TestConsulLogin(t *testing.T) {
 	t.Parallel()
@@ -46,7 +116,13 @@ func TestConsulLogin(t *testing.T) {
 	tokenFile := WriteTempFile(t, "")
 
 	client := startMockServer(t, &counter)
-	err := ConsulLogin(client, bearerTokenFile, testAuthMethod, tokenFile, "", testPodMeta)
+	_, err := ConsulLogin(LoginConfig{
+		Client:          client,
+		BearerTokenFile: bearerTokenFile,
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   tokenFile,
+		Meta:            testPodMeta,
+	})
 	require.NoError(err)
 	require.Equal(counter, 1)
 	// Validate that the token file was written to disk.
@@ -55,19 +131,261 @@ func TestConsulLogin(t *testing.T) {
 	require.Equal(string(data), "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586")
 }
 
+func TestConsulLogin_WriteTokenDigest(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	counter := 0
+	tokenFile := WriteTempFile(t, "")
+	t.Cleanup(func() {
+		os.Remove(tokenFile + ".sha256")
+	})
+	_, err := ConsulLogin(LoginConfig{
+		Client:           startMockServer(t, &counter),
+		BearerTokenFile:  WriteTempFile(t, "foo"),
+		AuthMethod:       testAuthMethod,
+		TokenSinkFile:    tokenFile,
+		Meta:             testPodMeta,
+		WriteTokenDigest: true,
+	})
+	require.NoError(err)
+
+	token, err := ioutil.ReadFile(tokenFile)
+	require.NoError(err)
+	digest, err := ioutil.ReadFile(tokenFile + ".sha256")
+	require.NoError(err)
+	expected := sha256.Sum256(token)
+	require.Equal(hex.EncodeToString(expected[:]), string(digest))
+}
+
+func TestConsulLogin_ResponseSinkFile(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	responseFile := filepath.Join(t.TempDir(), "login.json")
+	cfg := testLoginConfig(t, startMockServer(t, &counter))
+	cfg.ResponseSinkFile = responseFile
+	resp, err := ConsulLogin(cfg)
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(responseFile)
+	require.NoError(t, err)
+	var written LoginResponse
+	require.NoError(t, json.Unmarshal(data, &written))
+	require.Equal(t, *resp, written)
+	require.Equal(t, "926e2bd2-b344-d91b-0c83-ae89f372cd9b", written.AccessorID)
+}
+
+func TestConsulLogin_AuthMethodFile(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	var authMethod string
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		var params api.ACLLoginParams
+		require.NoError(json.NewDecoder(r.Body).Decode(&params))
+		authMethod = params.AuthMethod
+		w.Write([]byte(testLoginResponse))
+	})
+
+	_, err := ConsulLogin(LoginConfig{
+		Client:          client,
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethodFile:  WriteTempFile(t, " auth-method-from-file\n"),
+		TokenSinkFile:   WriteTempFile(t, ""),
+		Meta:            testPodMeta,
+	})
+	require.NoError(err)
+	require.Equal("auth-method-from-file", authMethod)
+}
+
+func TestConsulLogin_EmptyAuthMethodFile(t *testing.T) {
+	t.Parallel()
+	authMethodFile := WriteTempFile(t, "")
+	_, err := ConsulLogin(LoginConfig{
+		Client:          unreachableClient(t),
+		TokenSinkFile:   WriteTempFile(t, ""),
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethodFile:  authMethodFile,
+		Meta:            testPodMeta,
+	})
+	require.EqualError(t, err, fmt.Sprintf("no auth method found in %s", authMethodFile))
+}
+
+func TestConsulLogin_InvalidNamespace(t *testing.T) {
+	t.Parallel()
+	client, err := api.NewClient(&api.Config{Address: "127.0.0.1:1"})
+	require.NoError(t, err)
+	_, err = ConsulLogin(LoginConfig{
+		Client:          client,
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   WriteTempFile(t, ""),
+		Namespace:       "team_a",
+		Meta:            testPodMeta,
+	})
+	require.EqualError(t, err, `namespace "team_a" may only contain alphanumeric characters and dashes and must start and end with an alphanumeric character; did you mean "team-a"?`)
+}
+
+func TestConsulLogin_BearerTokenBase64(t *testing.T) {
+	t.Parallel()
+	var bearerToken string
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		var params api.ACLLoginParams
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+		bearerToken = params.BearerToken
+		w.Write([]byte(testLoginResponse))
+	})
+
+	_, err := ConsulLogin(LoginConfig{
+		Client:            client,
+		BearerTokenFile:   WriteTempFile(t, base64.StdEncoding.EncodeToString([]byte("bearer-token"))+"\n"),
+		BearerTokenBase64: true,
+		AuthMethod:        testAuthMethod,
+		TokenSinkFile:     WriteTempFile(t, ""),
+		Meta:              testPodMeta,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "bearer-token", bearerToken)
+
+	invalid := WriteTempFile(t, "not base64!")
+	_, err = ConsulLogin(LoginConfig{
+		Client:            client,
+		BearerTokenFile:   invalid,
+		BearerTokenBase64: true,
+		AuthMethod:        testAuthMethod,
+		TokenSinkFile:     WriteTempFile(t, ""),
+		Meta:              testPodMeta,
+	})
+	require.EqualError(t, err, fmt.Sprintf("bearer token in %s is not valid base64: illegal base64 data at input byte 3", invalid))
+}
+
+func TestConsulLogin_MaxTokenBytes(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	client := startMockServer(t, &counter)
+	oversized := WriteTempFile(t, strings.Repeat("a", 1025))
+
+	_, err := ConsulLogin(LoginConfig{
+		Client:          client,
+		BearerTokenFile: oversized,
+		MaxTokenBytes:   1024,
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   WriteTempFile(t, ""),
+		Meta:            testPodMeta,
+	})
+	require.EqualError(t, err, fmt.Sprintf("bearer token file %s is larger than 1024 bytes", oversized))
+	require.Zero(t, counter)
+
+	_, err = ConsulLogin(LoginConfig{
+		Client:          client,
+		BearerTokenFile: WriteTempFile(t, strings.Repeat("a", 1024)),
+		MaxTokenBytes:   1024,
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   WriteTempFile(t, ""),
+		Meta:            testPodMeta,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, counter)
+}
+
+// TestConsulLogin_MetaOrder ensures that the login request doesn't depend on
+// the order meta was built in, so requests can be compared byte for byte.
+func TestConsulLogin_MetaOrder(t *testing.T) {
+	t.Parallel()
+	var bodies [][]byte
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, body)
+		w.Write([]byte(testLoginResponse))
+	})
+
+	keys := []string{"pod", "namespace", "node", "zone", "app", "version", "team", "cluster"}
+	forward := make(map[string]string)
+	for _, k := range keys {
+		forward[k] = k + "-value"
+	}
+	backward := make(map[string]string)
+	for i := len(keys) - 1; i >= 0; i-- {
+		backward[keys[i]] = keys[i] + "-value"
+	}
+	require.Equal(t, forward, backward)
+
+	for _, meta := range []map[string]string{forward, backward} {
+		_, err := ConsulLogin(LoginConfig{
+			Client:          client,
+			BearerTokenFile: WriteTempFile(t, "foo"),
+			AuthMethod:      testAuthMethod,
+			TokenSinkFile:   WriteTempFile(t, ""),
+			Meta:            meta,
+		})
+		require.NoError(t, err)
+	}
+	require.Len(t, bodies, 2)
+	require.Equal(t, string(bodies[0]), string(bodies[1]))
+	require.Contains(t, string(bodies[0]), `"Meta":{"app":"app-value","cluster":"cluster-value","namespace":"namespace-value",`)
+}
+
+func TestConsulLogin_BearerTokenFallback(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"primary empty":   WriteTempFile(t, ""),
+		"primary missing": fmt.Sprintf("/foo/%d/%d", rand.Int(), rand.Int()),
+	}
+	for name, primary := range cases {
+		primary := primary
+		t.Run(name, func(t *testing.T) {
+			var bearerToken string
+			client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+				var params api.ACLLoginParams
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+				bearerToken = params.BearerToken
+				w.Write([]byte(testLoginResponse))
+			})
+
+			_, err := ConsulLogin(LoginConfig{
+				Client:           client,
+				BearerTokenFile:  primary,
+				BearerTokenFiles: []string{WriteTempFile(t, "secondary-token")},
+				AuthMethod:       testAuthMethod,
+				TokenSinkFile:    WriteTempFile(t, ""),
+				Meta:             testPodMeta,
+			})
+			require.NoError(t, err)
+			require.Equal(t, "secondary-token", bearerToken)
+		})
+	}
+}
+
+func TestConsulLogin_AllBearerTokenFilesEmpty(t *testing.T) {
+	t.Parallel()
+	primary := WriteTempFile(t, "")
+	secondary := WriteTempFile(t, "")
+	_, err := ConsulLogin(LoginConfig{
+		Client:           unreachableClient(t),
+		TokenSinkFile:    WriteTempFile(t, ""),
+		BearerTokenFile:  primary,
+		BearerTokenFiles: []string{secondary},
+		AuthMethod:       testAuthMethod,
+		Meta:             testPodMeta,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), fmt.Sprintf("no bearer token found in %s", primary))
+	require.Contains(t, err.Error(), fmt.Sprintf("no bearer token found in %s", secondary))
+}
+
 func TestConsulLogin_EmptyBearerTokenFile(t *testing.T) {
 	t.Parallel()
 	require := require.New(t)
 
 	bearerTokenFile := WriteTempFile(t, "")
-	err := ConsulLogin(
-		nil,
-		bearerTokenFile,
-		testAuthMethod,
-		"",
-		"",
-		testPodMeta,
-	)
+	_, err := ConsulLogin(LoginConfig{
+		Client:          unreachableClient(t),
+		TokenSinkFile:   WriteTempFile(t, ""),
+		BearerTokenFile: bearerTokenFile,
+		AuthMethod:      testAuthMethod,
+		Meta:            testPodMeta,
+	})
 	require.EqualError(err, fmt.Sprintf("no bearer token found in %s", bearerTokenFile))
 }
 
@@ -75,18 +393,138 @@ func TestConsulLogin_BearerTokenFileDoesNotExist(t *testing.T) {
 	t.Parallel()
 	require := require.New(t)
 	randFileName := fmt.Sprintf("/foo/%d/%d", rand.Int(), rand.Int())
-	err := ConsulLogin(
-		nil,
-		randFileName,
-		testAuthMethod,
-		"",
-		"",
-		testPodMeta,
-	)
+	_, err := ConsulLogin(LoginConfig{
+		Client:          unreachableClient(t),
+		TokenSinkFile:   WriteTempFile(t, ""),
+		BearerTokenFile: randFileName,
+		AuthMethod:      testAuthMethod,
+		Meta:            testPodMeta,
+	})
 	require.Error(err)
 	require.Contains(err.Error(), "unable to read bearerTokenFile")
 }
 
+func TestConsulLogin_ForbidTokenOverwrite(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		existing  string
+		forbid    bool
+		expErr    bool
+		expSecret string
+	}{
+		"overwrite allowed": {
+			existing:  "other-token",
+			forbid:    false,
+			expSecret: "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586",
+		},
+		"overwrite forbidden": {
+			existing:  "other-token",
+			forbid:    true,
+			expErr:    true,
+			expSecret: "other-token",
+		},
+		"forbidden with empty sink": {
+			existing:  "",
+			forbid:    true,
+			expSecret: "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586",
+		},
+		"forbidden with same token": {
+			existing:  "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586",
+			forbid:    true,
+			expSecret: "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			counter := 0
+			tokenFile := WriteTempFile(t, c.existing)
+			_, err := ConsulLogin(LoginConfig{
+				Client:               startMockServer(t, &counter),
+				BearerTokenFile:      WriteTempFile(t, "foo"),
+				AuthMethod:           testAuthMethod,
+				TokenSinkFile:        tokenFile,
+				ForbidTokenOverwrite: c.forbid,
+				Meta:                 testPodMeta,
+			})
+			if c.expErr {
+				require.EqualError(t, err, fmt.Sprintf("token sink %s already contains a different token and overwriting it is forbidden", tokenFile))
+			} else {
+				require.NoError(t, err)
+			}
+			data, err := ioutil.ReadFile(tokenFile)
+			require.NoError(t, err)
+			require.Equal(t, c.expSecret, string(data))
+		})
+	}
+}
+
+func TestLoginConfig_Validate(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	client := startMockServer(t, &counter)
+	cases := map[string]struct {
+		cfg    LoginConfig
+		expErr string
+	}{
+		"valid": {
+			cfg: LoginConfig{
+				Client:          client,
+				AuthMethod:      testAuthMethod,
+				BearerTokenFile: "/token",
+				TokenSinkFile:   "/sink",
+			},
+		},
+		"valid with alternatives": {
+			cfg: LoginConfig{
+				Client:           client,
+				AuthMethodFile:   "/auth-method",
+				BearerTokenFiles: []string{"/token"},
+				TokenSinkDir:     "/sink",
+			},
+		},
+		"several missing": {
+			cfg: LoginConfig{
+				Client:          client,
+				BearerTokenFile: "/token",
+			},
+			expErr: "login config is missing required settings: AuthMethod or AuthMethodFile, TokenSinkFile, TokenSinkDir or TokenSink",
+		},
+		"all missing": {
+			cfg:    LoginConfig{},
+			expErr: "login config is missing required settings: Client, AuthMethod or AuthMethodFile, BearerTokenFile or BearerTokenFiles, TokenSinkFile, TokenSinkDir or TokenSink",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			err := c.cfg.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
+
+// TestConsulLogin_NotInKubernetes ensures that a missing default service
+// account token is reported as not running in Kubernetes.
+func TestConsulLogin_NotInKubernetes(t *testing.T) {
+	t.Parallel()
+	defaultPath := filepath.Join(t.TempDir(), "serviceaccount", "token")
+	_, err := ConsulLogin(LoginConfig{
+		Client:                 unreachableClient(t),
+		TokenSinkFile:          WriteTempFile(t, ""),
+		BearerTokenFile:        defaultPath,
+		AuthMethod:             testAuthMethod,
+		Meta:                   testPodMeta,
+		defaultBearerTokenFile: defaultPath,
+	})
+	require.EqualError(t, err, "not running in kubernetes (no service account token found)")
+}
+
 func TestConsulLogin_TokenFileUnwritable(t *testing.T) {
 	t.Parallel()
 	require := require.New(t)
@@ -94,18 +532,108 @@ func TestConsulLogin_TokenFileUnwritable(t *testing.T) {
 	bearerTokenFile := WriteTempFile(t, "foo")
 	client := startMockServer(t, &counter)
 	randFileName := fmt.Sprintf("/foo/%d/%d", rand.Int(), rand.Int())
-	err := ConsulLogin(
-		client,
-		bearerTokenFile,
-		testAuthMethod,
-		randFileName,
-		"",
-		testPodMeta,
-	)
+	_, err := ConsulLogin(LoginConfig{
+		Client:          client,
+		BearerTokenFile: bearerTokenFile,
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   randFileName,
+		Meta:            testPodMeta,
+	})
 	require.Error(err)
 	require.Contains(err.Error(), "error writing token to file sink")
+	// The sink is checked before logging in so no token should be created.
+	require.Equal(0, counter)
+}
+
+func TestConsulLogin_RequiredServiceIdentity(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		serviceIdentity string
+		expErr          string
+	}{
+		"identity present": {
+			serviceIdentity: "example",
+		},
+		"identity missing": {
+			serviceIdentity: "other",
+			expErr:          `token from auth method "consul-k8s-auth-method" does not have required service identity "other"`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			counter := 0
+			tokenFile := WriteTempFile(t, "")
+			_, err := ConsulLogin(LoginConfig{
+				Client:                  startMockServer(t, &counter),
+				BearerTokenFile:         WriteTempFile(t, "foo"),
+				AuthMethod:              testAuthMethod,
+				TokenSinkFile:           tokenFile,
+				Meta:                    testPodMeta,
+				RequiredServiceIdentity: c.serviceIdentity,
+			})
+			require.Equal(t, 1, counter)
+			data, readErr := ioutil.ReadFile(tokenFile)
+			require.NoError(t, readErr)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				// The token should not have been written out.
+				require.Empty(t, data)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", string(data))
+		})
+	}
+}
+
+// TestRunLoginLoop ensures that the login loop logs in once per interval
+// until its context is cancelled.
+func TestRunLoginLoop(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	counter := 0
+	bearerTokenFile := WriteTempFile(t, "foo")
+	tokenFile := WriteTempFile(t, "")
+	client := startMockServer(t, &counter)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	waits := 0
+	// fakeAfter fires immediately for the first two intervals and then
+	// cancels the loop, so we expect exactly three logins.
+	fakeAfter := func(d time.Duration) <-chan time.Time {
+		require.GreaterOrEqual(int64(d), int64(time.Second))
+		require.Less(int64(d), int64(1100*time.Millisecond))
+		waits++
+		ch := make(chan time.Time, 1)
+		if waits == 3 {
+			cancel()
+			return ch
+		}
+		ch <- time.Now()
+		return ch
+	}
+
+	RunLoginLoop(ctx, LoginConfig{
+		Client:          client,
+		BearerTokenFile: bearerTokenFile,
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   tokenFile,
+		Meta:            testPodMeta,
+		Clock:           afterFuncClock(fakeAfter),
+	}, 1*time.Second)
+	require.Equal(3, counter)
+	require.Equal(3, waits)
 }
 
+// afterFuncClock is a Clock whose After is the function itself.
+type afterFuncClock func(time.Duration) <-chan time.Time
+
+func (f afterFuncClock) Now() time.Time                         { return time.Now() }
+func (f afterFuncClock) After(d time.Duration) <-chan time.Time { return f(d) }
+
 func TestWriteFileWithPerms_InvalidOutputFile(t *testing.T) {
 	t.Parallel()
 	rand.Seed(time.Now().UnixNano())
@@ -157,10 +685,74 @@ func TestWriteFileWithPerms(t *testing.T) {
 	require.Equal(t, payload, string(data))
 }
 
+func TestWriteFileWithPerms_Fsync(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "token")
+
+	var synced []string
+	recordSync := func(o *writeOptions) {
+		o.sync = func(f *os.File) error {
+			synced = append(synced, f.Name())
+			return f.Sync()
+		}
+	}
+	err := WriteFileWithPerms(outputFile, "foo", 0444, WithFsync(), recordSync)
+	require.NoError(t, err)
+	// Both the file and its directory must be synced.
+	require.Equal(t, []string{outputFile, dir}, synced)
+
+	data, err := ioutil.ReadFile(outputFile)
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(data))
+	info, err := os.Stat(outputFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0444), info.Mode())
+}
+
+func TestWriteFileWithPerms_NoFsyncByDefault(t *testing.T) {
+	t.Parallel()
+	synced := false
+	recordSync := func(o *writeOptions) {
+		o.sync = func(*os.File) error {
+			synced = true
+			return nil
+		}
+	}
+	err := WriteFileWithPerms(filepath.Join(t.TempDir(), "token"), "foo", 0444, recordSync)
+	require.NoError(t, err)
+	require.False(t, synced)
+}
+
+func TestWriteFileWithPerms_ShortWrite(t *testing.T) {
+	t.Parallel()
+	shortWrite := func(o *writeOptions) {
+		o.write = func(f *os.File, b []byte) (int, error) {
+			return f.Write(b[:len(b)-1])
+		}
+	}
+	err := WriteFileWithPerms(filepath.Join(t.TempDir(), "token"), "foo", 0444, shortWrite)
+	require.EqualError(t, err, "unable to write file: short write of 2 out of 3 bytes")
+}
+
 // startMockServer starts an httptest server used to mock a Consul server's
 // /v1/acl/login endpoint. apiCallCounter will be incremented on each call to /v1/acl/login.
 // It returns a consul client pointing at the server.
 func startMockServer(t *testing.T, apiCallCounter *int) *api.Client {
+	consulServer := startMockLoginServer(t, apiCallCounter)
+
+	serverURL, err := url.Parse(consulServer.URL)
+	require.NoError(t, err)
+	clientConfig := &api.Config{Address: serverURL.String()}
+	client, err := api.NewClient(clientConfig)
+	require.NoError(t, err)
+
+	return client
+}
+
+// startMockLoginServer starts the server used by startMockServer and returns
+// it rather than a client, for tests that need to configure the client themselves.
+func startMockLoginServer(t *testing.T, apiCallCounter *int) *httptest.Server {
 
 	// Start the Consul server.
 	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -171,16 +763,63 @@ func startMockServer(t *testing.T, apiCallCounter *int) *api.Client {
 		w.Write([]byte(testLoginResponse))
 	}))
 	t.Cleanup(consulServer.Close)
+	return consulServer
+}
 
-	serverURL, err := url.Parse(consulServer.URL)
-	require.NoError(t, err)
-	clientConfig := &api.Config{Address: serverURL.String()}
-	client, err := api.NewClient(clientConfig)
+// unreachableClient returns a client for an address nothing listens on, for
+// tests that fail before any request is made.
+func unreachableClient(t *testing.T) *api.Client {
+	t.Helper()
+	client, err := api.NewClient(&api.Config{Address: "127.0.0.1:1"})
 	require.NoError(t, err)
+	return client
+}
 
+// startMockConsul starts an httptest server that mocks a Consul server with
+// handler. It returns a consul client pointing at the server.
+func startMockConsul(t *testing.T, handler http.HandlerFunc) *api.Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
 	return client
 }
 
+// startFailingLoginServer starts a mock Consul server whose first failures
+// calls to /v1/acl/login fail with a 500 and later ones succeed, or all of
+// them if failures is negative. logins is atomically incremented on each call
+// to /v1/acl/login. Other requests get a 404. It returns a consul client
+// pointing at the server.
+func startFailingLoginServer(t *testing.T, logins *int32, failures int) *api.Client {
+	t.Helper()
+	return startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/acl/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if n := atomic.AddInt32(logins, 1); failures < 0 || n <= int32(failures) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("boom"))
+			return
+		}
+		w.Write([]byte(testLoginResponse))
+	})
+}
+
+// testLoginConfig returns the LoginConfig of a login to client with
+// testAuthMethod, a temporary bearer token file and a temporary token sink.
+func testLoginConfig(t *testing.T, client *api.Client) LoginConfig {
+	t.Helper()
+	return LoginConfig{
+		Client:          client,
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   WriteTempFile(t, ""),
+		Meta:            testPodMeta,
+	}
+}
+
 const testAuthMethod = "consul-k8s-auth-method"
 const testLoginResponse = `{
   "AccessorID": "926e2bd2-b344-d91b-0c83-ae89f372cd9b",
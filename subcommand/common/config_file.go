@@ -0,0 +1,99 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// loginConfigFile is the format of the file read by LoadLoginConfig. Only
+// the settings that can be expressed in a file are supported; clients,
+// metrics and loggers still have to be set in code.
+type loginConfigFile struct {
+	BearerTokenFile         string            `json:"bearerTokenFile"`
+	BearerTokenFiles        []string          `json:"bearerTokenFiles"`
+	BearerTokenBase64       bool              `json:"bearerTokenBase64"`
+	MaxTokenBytes           int64             `json:"maxTokenBytes"`
+	AuthMethod              string            `json:"authMethod"`
+	AuthMethodFile          string            `json:"authMethodFile"`
+	RequiredAuthMethodType  string            `json:"requiredAuthMethodType"`
+	TokenSinkFile           string            `json:"tokenSinkFile"`
+	SinkFilePrefix          string            `json:"sinkFilePrefix"`
+	SinkFileSuffix          string            `json:"sinkFileSuffix"`
+	TokenSinkDir            string            `json:"tokenSinkDir"`
+	ForbidTokenOverwrite    bool              `json:"forbidTokenOverwrite"`
+	SinkPathPrefix          string            `json:"sinkPathPrefix"`
+	ForbidHostPathSink      bool              `json:"forbidHostPathSink"`
+	TokenEncryptionKeyEnv   string            `json:"tokenEncryptionKeyEnv"`
+	WriteTokenDigest        bool              `json:"writeTokenDigest"`
+	ConfirmSinkWrite        bool              `json:"confirmSinkWrite"`
+	ResponseSinkFile        string            `json:"responseSinkFile"`
+	Namespace               string            `json:"namespace"`
+	Meta                    map[string]string `json:"meta"`
+	RequiredServiceIdentity string            `json:"requiredServiceIdentity"`
+	LockFile                string            `json:"lockFile"`
+	PIDFile                 string            `json:"pidFile"`
+	Preflight               bool              `json:"preflight"`
+	AuditFile               string            `json:"auditFile"`
+	MaxLogBytes             int               `json:"maxLogBytes"`
+}
+
+// LoadLoginConfig reads login parameters from the file at path for setups
+// that are too complex for flags. The file is parsed as YAML if its
+// extension is .yaml or .yml and as JSON if it is .json. Unknown fields are
+// an error so that typos don't silently fall back to defaults.
+func LoadLoginConfig(path string) (LoginConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return LoginConfig{}, fmt.Errorf("unable to read login config file: %s", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".yaml", ".yml":
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			return LoginConfig{}, fmt.Errorf("unable to parse login config file %s: %s", path, err)
+		}
+	default:
+		return LoginConfig{}, fmt.Errorf("unsupported login config file extension %q: must be .json, .yaml or .yml", ext)
+	}
+
+	var f loginConfigFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return LoginConfig{}, fmt.Errorf("unable to parse login config file %s: %s", path, err)
+	}
+	return LoginConfig{
+		BearerTokenFile:         f.BearerTokenFile,
+		BearerTokenFiles:        f.BearerTokenFiles,
+		BearerTokenBase64:       f.BearerTokenBase64,
+		MaxTokenBytes:           f.MaxTokenBytes,
+		AuthMethod:              f.AuthMethod,
+		AuthMethodFile:          f.AuthMethodFile,
+		RequiredAuthMethodType:  f.RequiredAuthMethodType,
+		TokenSinkFile:           f.TokenSinkFile,
+		SinkFilePrefix:          f.SinkFilePrefix,
+		SinkFileSuffix:          f.SinkFileSuffix,
+		TokenSinkDir:            f.TokenSinkDir,
+		ForbidTokenOverwrite:    f.ForbidTokenOverwrite,
+		SinkPathPrefix:          f.SinkPathPrefix,
+		ForbidHostPathSink:      f.ForbidHostPathSink,
+		TokenEncryptionKeyEnv:   f.TokenEncryptionKeyEnv,
+		WriteTokenDigest:        f.WriteTokenDigest,
+		ConfirmSinkWrite:        f.ConfirmSinkWrite,
+		ResponseSinkFile:        f.ResponseSinkFile,
+		Namespace:               f.Namespace,
+		Meta:                    f.Meta,
+		RequiredServiceIdentity: f.RequiredServiceIdentity,
+		LockFile:                f.LockFile,
+		PIDFile:                 f.PIDFile,
+		Preflight:               f.Preflight,
+		AuditFile:               f.AuditFile,
+		MaxLogBytes:             f.MaxLogBytes,
+	}, nil
+}
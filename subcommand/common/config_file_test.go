@@ -0,0 +1,86 @@
+package common
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadLoginConfig(t *testing.T) {
+	t.Parallel()
+	exp := LoginConfig{
+		BearerTokenFile:  "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		BearerTokenFiles: []string{"/fallback/token"},
+		AuthMethod:       testAuthMethod,
+		TokenSinkFile:    "/consul/connect-inject/acl-token",
+		WriteTokenDigest: true,
+		Namespace:        "ns",
+		Meta:             map[string]string{"pod": "default/pod"},
+	}
+	cases := map[string]string{
+		"config.yaml": `
+bearerTokenFile: /var/run/secrets/kubernetes.io/serviceaccount/token
+bearerTokenFiles:
+  - /fallback/token
+authMethod: consul-k8s-auth-method
+tokenSinkFile: /consul/connect-inject/acl-token
+writeTokenDigest: true
+namespace: ns
+meta:
+  pod: default/pod
+`,
+		"config.json": `{
+  "bearerTokenFile": "/var/run/secrets/kubernetes.io/serviceaccount/token",
+  "bearerTokenFiles": ["/fallback/token"],
+  "authMethod": "consul-k8s-auth-method",
+  "tokenSinkFile": "/consul/connect-inject/acl-token",
+  "writeTokenDigest": true,
+  "namespace": "ns",
+  "meta": {"pod": "default/pod"}
+}`,
+	}
+	for name, contents := range cases {
+		name, contents := name, contents
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), name)
+			require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+			cfg, err := LoadLoginConfig(path)
+			require.NoError(t, err)
+			require.Equal(t, exp, cfg)
+		})
+	}
+}
+
+func TestLoadLoginConfig_Errors(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		file     string
+		contents string
+		expErr   string
+	}{
+		"unknown field": {
+			file:     "config.yaml",
+			contents: "authMethod: foo\nbearerToken: secret\n",
+			expErr:   `json: unknown field "bearerToken"`,
+		},
+		"unsupported extension": {
+			file:     "config.toml",
+			contents: `authMethod = "foo"`,
+			expErr:   `unsupported login config file extension ".toml": must be .json, .yaml or .yml`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), c.file)
+			require.NoError(t, ioutil.WriteFile(path, []byte(c.contents), 0600))
+			_, err := LoadLoginConfig(path)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.expErr)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// agentDatacenter returns the datacenter of the agent client talks to.
+func agentDatacenter(client *api.Client) (string, error) {
+	self, err := client.Agent().Self()
+	if err != nil {
+		return "", fmt.Errorf("unable to query agent: %s", err)
+	}
+	dc, ok := self["Config"]["Datacenter"].(string)
+	if !ok || dc == "" {
+		return "", fmt.Errorf("agent did not report its datacenter")
+	}
+	return dc, nil
+}
+
+// SameDatacenter returns true if a and b talk to agents in the same
+// datacenter. It is used by federation commands that need clients for two
+// different datacenters.
+func SameDatacenter(a, b *api.Client) (bool, error) {
+	dcA, err := agentDatacenter(a)
+	if err != nil {
+		return false, err
+	}
+	dcB, err := agentDatacenter(b)
+	if err != nil {
+		return false, err
+	}
+	return dcA == dcB, nil
+}
+
+// ValidateDatacenter returns an error if the agent client talks to is not in
+// the expected datacenter. It is used to catch a -datacenter flag that does
+// not match the server. If expected is empty, no check is done.
+func ValidateDatacenter(client *api.Client, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	dc, err := agentDatacenter(client)
+	if err != nil {
+		return err
+	}
+	if dc != expected {
+		return fmt.Errorf("-datacenter is %q but the Consul server is in datacenter %q", expected, dc)
+	}
+	return nil
+}
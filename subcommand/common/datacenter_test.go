@@ -0,0 +1,75 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSameDatacenter(t *testing.T) {
+	t.Parallel()
+	dc1 := startMockAgentSelfServer(t, "dc1")
+	dc2 := startMockAgentSelfServer(t, "dc2")
+	otherDC1 := startMockAgentSelfServer(t, "dc1")
+
+	same, err := SameDatacenter(dc1, dc2)
+	require.NoError(t, err)
+	require.False(t, same)
+
+	same, err = SameDatacenter(dc1, otherDC1)
+	require.NoError(t, err)
+	require.True(t, same)
+}
+
+func TestSameDatacenter_NoDatacenter(t *testing.T) {
+	t.Parallel()
+	_, err := SameDatacenter(startMockAgentSelfServer(t, ""), startMockAgentSelfServer(t, "dc1"))
+	require.EqualError(t, err, "agent did not report its datacenter")
+}
+
+func TestValidateDatacenter(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		expected string
+		expErr   string
+	}{
+		"matching": {
+			expected: "dc1",
+		},
+		"mismatching": {
+			expected: "dc2",
+			expErr:   `-datacenter is "dc2" but the Consul server is in datacenter "dc1"`,
+		},
+		"empty skips the check": {
+			expected: "",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			err := ValidateDatacenter(startMockAgentSelfServer(t, "dc1"), c.expected)
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
+
+// startMockAgentSelfServer starts a server mocking /v1/agent/self for an
+// agent in datacenter dc and returns a Consul client pointing at it.
+func startMockAgentSelfServer(t *testing.T, dc string) *api.Client {
+	t.Helper()
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/agent/self" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"Config": {"Datacenter": %q, "NodeName": "node"}}`, dc)
+	})
+	return client
+}
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// diagnoseTimeout bounds each of the checks made by DiagnoseLoginFailure so
+// that diagnosing an unreachable server doesn't hang.
+const diagnoseTimeout = 5 * time.Second
+
+// DiagnosedError is returned by ConsulLogin when the login request failed.
+// It keeps the report of DiagnoseLoginFailure apart from the login error so
+// that code inspecting the error, such as ClassifyError, isn't misled by the
+// errors of the diagnostic checks.
+type DiagnosedError struct {
+	// Err is the login error.
+	Err error
+	// Diagnostics is the report of DiagnoseLoginFailure.
+	Diagnostics string
+}
+
+func (e *DiagnosedError) Error() string { return e.Err.Error() + "\n" + e.Diagnostics }
+
+func (e *DiagnosedError) Unwrap() error { return e.Err }
+
+// withoutDiagnostics returns the login error of err if it is a
+// DiagnosedError and err otherwise.
+func withoutDiagnostics(err error) error {
+	var diagnosed *DiagnosedError
+	if errors.As(err, &diagnosed) {
+		return diagnosed.Err
+	}
+	return err
+}
+
+// DiagnoseLoginFailure runs a few checks that help explain why logging in with
+// cfg failed and returns a multi-line report of the results: whether the
+// Consul server is reachable, whether the auth method exists and whether the
+// bearer token looks like a JWT.
+func DiagnoseLoginFailure(client *api.Client, cfg LoginConfig) string {
+	var report []string
+
+	reachable := true
+	ctx, cancel := context.WithTimeout(context.Background(), diagnoseTimeout)
+	defer cancel()
+	if _, err := client.Status().LeaderWithQueryOptions((&api.QueryOptions{}).WithContext(ctx)); err != nil {
+		reachable = false
+		report = append(report, fmt.Sprintf("cannot reach Consul server: %s", err))
+	} else {
+		report = append(report, "Consul server is reachable")
+	}
+
+	if reachable {
+		opts := (&api.QueryOptions{Namespace: cfg.Namespace}).WithContext(ctx)
+		method, _, err := client.ACL().AuthMethodRead(cfg.AuthMethod, opts)
+		switch {
+		case err != nil:
+			report = append(report, fmt.Sprintf("unable to check auth method %q: %s", cfg.AuthMethod, err))
+		case method == nil:
+			report = append(report, fmt.Sprintf("auth method %q does not exist", cfg.AuthMethod))
+		default:
+			report = append(report, fmt.Sprintf("auth method %q exists and has type %q", cfg.AuthMethod, method.Type))
+		}
+	}
+
+	bearerToken, err := readBearerToken(cfg)
+	switch {
+	case err != nil:
+		report = append(report, fmt.Sprintf("unable to read bearer token: %s", err))
+	case !looksLikeJWT(bearerToken):
+		report = append(report, "bearer token is not a JWT")
+	default:
+		report = append(report, "bearer token is a JWT")
+	}
+
+	return "Login diagnostics:\n  - " + strings.Join(report, "\n  - ")
+}
+
+// looksLikeJWT returns true if token has three dot separated parts, the first
+// of which is a base64url encoded JSON header with an "alg" field.
+func looksLikeJWT(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	return json.Unmarshal(header, &h) == nil && h.Alg != ""
+}
+
+// AgentSelf returns the configuration and member information of the agent
+// client talks to, as returned by /v1/agent/self, so that diagnostic code can
+// inspect settings such as whether ACLs and TLS are enabled. The returned
+// sections, such as "Config" and "DebugConfig", vary with the Consul version.
+func AgentSelf(client *api.Client) (map[string]interface{}, error) {
+	sections, err := client.Agent().Self()
+	if err != nil {
+		return nil, fmt.Errorf("unable to query agent: %s", err)
+	}
+	self := make(map[string]interface{}, len(sections))
+	for name, section := range sections {
+		self[name] = section
+	}
+	return self, nil
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LoginError is a failed login recorded by LoginErrorHistory.
+type LoginError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// LoginErrorHistory keeps the most recent login errors for diagnostics. Set
+// it on LoginConfig.ErrorHistory to have RunLoginLoop record every failed
+// login. It is an http.Handler that serves the errors as JSON so that it can
+// be mounted on a debug endpoint. It is safe for concurrent use.
+type LoginErrorHistory struct {
+	mu      sync.Mutex
+	entries []LoginError
+	// next is the index in entries the next error is written to once
+	// entries is full.
+	next int
+}
+
+// NewLoginErrorHistory returns a LoginErrorHistory that keeps the last size
+// errors.
+func NewLoginErrorHistory(size int) *LoginErrorHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &LoginErrorHistory{entries: make([]LoginError, 0, size)}
+}
+
+// Add records that a login failed at t with err. If the history is full the
+// oldest error is dropped.
+func (h *LoginErrorHistory) Add(t time.Time, err error) {
+	if h == nil || err == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := LoginError{Time: t, Error: err.Error()}
+	if len(h.entries) < cap(h.entries) {
+		h.entries = append(h.entries, e)
+		return
+	}
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+}
+
+// Entries returns the recorded errors, oldest first.
+func (h *LoginErrorHistory) Entries() []LoginError {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]LoginError, 0, len(h.entries))
+	out = append(out, h.entries[h.next:]...)
+	return append(out, h.entries[:h.next]...)
+}
+
+// ServeHTTP implements http.Handler by writing Entries as JSON.
+func (h *LoginErrorHistory) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Entries())
+}
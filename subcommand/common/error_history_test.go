@@ -0,0 +1,42 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginErrorHistory(t *testing.T) {
+	t.Parallel()
+	h := NewLoginErrorHistory(3)
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		h.Add(start.Add(time.Duration(i)*time.Second), fmt.Errorf("error %d", i))
+	}
+	// Successful logins aren't recorded.
+	h.Add(start, nil)
+
+	var msgs []string
+	for _, e := range h.Entries() {
+		msgs = append(msgs, e.Error)
+	}
+	require.Equal(t, []string{"error 2", "error 3", "error 4"}, msgs)
+}
+
+func TestLoginErrorHistory_ServeHTTP(t *testing.T) {
+	t.Parallel()
+	h := NewLoginErrorHistory(2)
+	h.Add(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), errors.New("error logging in"))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/login-errors", nil))
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var entries []LoginError
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	require.Equal(t, []LoginError{{Time: time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), Error: "error logging in"}}, entries)
+}
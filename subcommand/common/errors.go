@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrorClass says whether an error is worth retrying.
+type ErrorClass int
+
+const (
+	// ErrorClassUnknown is returned for nil errors and errors that can't be
+	// classified.
+	ErrorClassUnknown ErrorClass = iota
+	// ErrorClassRetryable is returned for errors that are likely to go away
+	// on their own, such as connection failures and server errors.
+	ErrorClassRetryable
+	// ErrorClassPermanent is returned for errors that will keep happening
+	// until the configuration changes, such as permission denied.
+	ErrorClassPermanent
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassRetryable:
+		return "retryable"
+	case ErrorClassPermanent:
+		return "permanent"
+	default:
+		return "unknown"
+	}
+}
+
+// responseCodeRe matches the status code in the errors returned by the
+// Consul API client for non-2xx responses.
+var responseCodeRe = regexp.MustCompile(`Unexpected response code: (\d{3})`)
+
+// ClassifyError returns the class of err so that retry logic can tell
+// transient failures apart from ones that need a configuration change. It is
+// also useful to callers that want to alert on permanent errors only. Errors
+// returned by ConsulLogin can be passed as is: since they don't always wrap
+// the underlying error, their message is inspected too. The diagnostics of a
+// DiagnosedError are ignored.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorClassUnknown
+	}
+	err = withoutDiagnostics(err)
+	if isConnectionErr(err) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassRetryable
+	}
+	msg := err.Error()
+	if isACLDisabledErr(err) {
+		return ErrorClassPermanent
+	}
+	if code, ok := responseCode(msg); ok {
+		switch {
+		case code == 429 || code >= 500:
+			return ErrorClassRetryable
+		case code >= 400:
+			return ErrorClassPermanent
+		}
+	}
+	if isNetworkErrMsg(msg) {
+		return ErrorClassRetryable
+	}
+	return ErrorClassUnknown
+}
+
+// HumanizeConsulError returns the message of err prefixed with guidance on
+// how to fix it, for the errors operators most commonly run into. The raw
+// errors returned by the Consul API client, such as
+// "Unexpected response code: 403 (Permission denied)", don't say what to
+// check. It returns an empty string if err is nil.
+func HumanizeConsulError(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	// The hint is only about the login error, not its diagnostics.
+	err = withoutDiagnostics(err)
+	loginMsg := err.Error()
+	var hint string
+	if code, ok := responseCode(loginMsg); ok {
+		switch {
+		case isACLDisabledErr(err):
+			hint = "ACLs are not enabled on the Consul servers; enable ACLs or don't configure an auth method"
+		case code == 400:
+			hint = "Consul rejected the request as invalid; check the auth method, namespace and meta being sent"
+		case code == 401 || code == 403:
+			hint = "Consul denied permission; check that the auth method's binding rules match this service account " +
+				"and that the token used has the required policies"
+		case code == 404:
+			hint = "Consul could not find what was requested; check the auth method name and namespace"
+		case code == 429:
+			hint = "Consul is rate limiting requests; retry later or raise the servers' rate limits"
+		case code >= 500:
+			hint = "the Consul servers had an internal error; check that they are healthy and have a leader"
+		}
+	} else if strings.Contains(loginMsg, "x509:") || strings.Contains(loginMsg, "tls:") {
+		hint = "the TLS connection to Consul failed; check -ca-file and -tls-server-name"
+	} else if isNetworkErrMsg(loginMsg) {
+		hint = "cannot connect to Consul; check -http-addr and that the servers are running and reachable from this pod"
+	}
+	if hint == "" {
+		return msg
+	}
+	return fmt.Sprintf("%s: %s", hint, msg)
+}
+
+// responseCode returns the HTTP status code in msg if it's the message of an
+// error the Consul API client returned for a non-2xx response.
+func responseCode(msg string) (int, bool) {
+	m := responseCodeRe.FindStringSubmatch(msg)
+	if m == nil {
+		return 0, false
+	}
+	code, err := strconv.Atoi(m[1])
+	return code, err == nil
+}
+
+// isNetworkErrMsg returns true if msg looks like the message of an error
+// caused by the network rather than by Consul.
+func isNetworkErrMsg(msg string) bool {
+	for _, s := range []string{"connection refused", "connection reset", "no such host", "i/o timeout"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
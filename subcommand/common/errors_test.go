@@ -0,0 +1,124 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	client, err := api.NewClient(&api.Config{Address: down.URL})
+	require.NoError(t, err)
+	_, connRefused := client.Status().Leader()
+	require.Error(t, connRefused)
+
+	cases := map[string]struct {
+		err error
+		exp ErrorClass
+	}{
+		"nil": {
+			err: nil,
+			exp: ErrorClassUnknown,
+		},
+		"connection refused": {
+			err: connRefused,
+			exp: ErrorClassRetryable,
+		},
+		"connection refused from ConsulLogin": {
+			err: fmt.Errorf("error logging in: %s", connRefused),
+			exp: ErrorClassRetryable,
+		},
+		"permission denied": {
+			err: fmt.Errorf("error logging in: %s", errors.New("Unexpected response code: 403 (Permission denied)")),
+			exp: ErrorClassPermanent,
+		},
+		"transient error with diagnostics": {
+			err: &DiagnosedError{
+				Err:         fmt.Errorf("error logging in: %w", errors.New("Post \"http://consul:8500/v1/acl/login\": EOF")),
+				Diagnostics: "Login diagnostics:\n  - unable to check auth method \"k8s\": Unexpected response code: 403 (Permission denied)",
+			},
+			exp: ErrorClassUnknown,
+		},
+		"permission denied with diagnostics": {
+			err: &DiagnosedError{
+				Err:         fmt.Errorf("error logging in: %w", errors.New("Unexpected response code: 403 (Permission denied)")),
+				Diagnostics: "Login diagnostics:\n  - Consul server is reachable",
+			},
+			exp: ErrorClassPermanent,
+		},
+		"server error": {
+			err: errors.New("Unexpected response code: 500 (rpc error: No cluster leader)"),
+			exp: ErrorClassRetryable,
+		},
+		"ACLs disabled": {
+			err: errors.New("Unexpected response code: 401 (ACL support disabled)"),
+			exp: ErrorClassPermanent,
+		},
+		"other": {
+			err: errors.New("no bearer token found in /token"),
+			exp: ErrorClassUnknown,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.exp, ClassifyError(c.err))
+		})
+	}
+}
+
+func TestHumanizeConsulError(t *testing.T) {
+	t.Parallel()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	client, err := api.NewClient(&api.Config{Address: down.URL})
+	require.NoError(t, err)
+	_, connRefused := client.Status().Leader()
+	require.Error(t, connRefused)
+
+	cases := map[string]struct {
+		err       error
+		expPrefix string
+	}{
+		"nil": {
+			err:       nil,
+			expPrefix: "",
+		},
+		"permission denied": {
+			err:       errors.New("Unexpected response code: 403 (Permission denied)"),
+			expPrefix: "Consul denied permission; check that the auth method's binding rules match",
+		},
+		"server error": {
+			err:       errors.New("Unexpected response code: 500 (rpc error: No cluster leader)"),
+			expPrefix: "the Consul servers had an internal error; check that they are healthy and have a leader",
+		},
+		"connection refused": {
+			err:       connRefused,
+			expPrefix: "cannot connect to Consul; check -http-addr",
+		},
+		"unknown": {
+			err:       errors.New("something else"),
+			expPrefix: "something else",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			msg := HumanizeConsulError(c.err)
+			require.True(t, strings.HasPrefix(msg, c.expPrefix), msg)
+			// The original error is always kept.
+			if c.err != nil {
+				require.True(t, strings.HasSuffix(msg, c.err.Error()), msg)
+			}
+		})
+	}
+}
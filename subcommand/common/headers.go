@@ -0,0 +1,55 @@
+package common
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// reservedHeaders are the headers that carry credentials or identify the
+// request. Overriding them is allowed but almost always a mistake.
+var reservedHeaders = []string{"Authorization", "X-Consul-Token", RequestIDHeader}
+
+// MergeHeaders returns a copy of defaults with the headers in user added.
+// User headers replace default headers with the same name. A warning is
+// logged when a user header replaces one of the reserved headers, such as
+// Authorization or X-Consul-Token, to hclog's default logger. Neither
+// defaults nor user is modified.
+func MergeHeaders(defaults, user http.Header) http.Header {
+	return mergeHeaders(hclog.Default(), defaults, user)
+}
+
+// mergeHeaders is MergeHeaders logging to logger.
+func mergeHeaders(logger hclog.Logger, defaults, user http.Header) http.Header {
+	merged := defaults.Clone()
+	if merged == nil {
+		merged = make(http.Header)
+	}
+	names := make([]string, 0, len(user))
+	for name := range user {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := http.CanonicalHeaderKey(name)
+		if isReservedHeader(key) {
+			if _, ok := merged[key]; ok {
+				logger.Warn("Overriding reserved HTTP header", "header", key)
+			}
+		}
+		merged[key] = append([]string(nil), user[name]...)
+	}
+	return merged
+}
+
+// isReservedHeader returns true if the canonical header name key is one of
+// reservedHeaders.
+func isReservedHeader(key string) bool {
+	for _, h := range reservedHeaders {
+		if key == http.CanonicalHeaderKey(h) {
+			return true
+		}
+	}
+	return false
+}
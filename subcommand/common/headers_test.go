@@ -0,0 +1,69 @@
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeHeaders(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		defaults http.Header
+		user     http.Header
+		exp      http.Header
+		expWarn  string
+	}{
+		"no user headers": {
+			defaults: http.Header{"User-Agent": {"consul-k8s"}},
+			exp:      http.Header{"User-Agent": {"consul-k8s"}},
+		},
+		"no defaults": {
+			user: http.Header{"X-Team": {"a"}},
+			exp:  http.Header{"X-Team": {"a"}},
+		},
+		"user overrides default": {
+			defaults: http.Header{"User-Agent": {"consul-k8s"}, "Accept": {"application/json"}},
+			user:     http.Header{"user-agent": {"custom"}},
+			exp:      http.Header{"User-Agent": {"custom"}, "Accept": {"application/json"}},
+		},
+		"user overrides reserved header": {
+			defaults: http.Header{"Authorization": {"Bearer default"}},
+			user:     http.Header{"Authorization": {"Bearer user"}},
+			exp:      http.Header{"Authorization": {"Bearer user"}},
+			expWarn:  "[WARN]  Overriding reserved HTTP header: header=Authorization",
+		},
+		"user sets reserved header without default": {
+			user: http.Header{"X-Consul-Token": {"token"}},
+			exp:  http.Header{"X-Consul-Token": {"token"}},
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var logs bytes.Buffer
+			logger := hclog.New(&hclog.LoggerOptions{Output: &logs})
+			merged := mergeHeaders(logger, c.defaults, c.user)
+			require.Equal(t, c.exp, merged)
+			if c.expWarn == "" {
+				require.Empty(t, logs.String())
+			} else {
+				require.Contains(t, logs.String(), c.expWarn)
+			}
+		})
+	}
+}
+
+func TestMergeHeaders_DoesNotModifyInputs(t *testing.T) {
+	t.Parallel()
+	defaults := http.Header{"Accept": {"application/json"}}
+	user := http.Header{"Accept": {"text/plain"}}
+	merged := MergeHeaders(defaults, user)
+	merged.Add("Accept", "*/*")
+	require.Equal(t, http.Header{"Accept": {"application/json"}}, defaults)
+	require.Equal(t, http.Header{"Accept": {"text/plain"}}, user)
+}
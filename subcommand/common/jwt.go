@@ -0,0 +1,47 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	// authMethodTypeJWT is the type of Consul's JWT auth method.
+	authMethodTypeJWT = "jwt"
+	// audienceParam is the login query parameter the audience is sent in.
+	audienceParam = "audience"
+	// loginPath is the path of Consul's login endpoint.
+	loginPath = "/v1/acl/login"
+)
+
+// JWTLogin logs in like ConsulLogin with an auth method of type jwt. The
+// bearer token is passed to Consul as is. To send the audience the token was
+// issued for, build cfg.Client with ClientOptions.Audience. The auth
+// method's type is only checked if cfg.RequiredAuthMethodType is set, since
+// reading the auth method needs a token with acl:read, and it must then be
+// "jwt".
+func JWTLogin(cfg LoginConfig) (*LoginResponse, error) {
+	if cfg.RequiredAuthMethodType != "" && cfg.RequiredAuthMethodType != authMethodTypeJWT {
+		return nil, fmt.Errorf("RequiredAuthMethodType must be %q for a JWT login, not %q", authMethodTypeJWT, cfg.RequiredAuthMethodType)
+	}
+	return ConsulLogin(cfg)
+}
+
+// audienceTransport is an http.RoundTripper that sets the audience query
+// parameter of login requests.
+type audienceTransport struct {
+	audience string
+	next     http.RoundTripper
+}
+
+func (t *audienceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.URL.Path != loginPath {
+		return t.next.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the request they are given.
+	req = req.Clone(req.Context())
+	q := req.URL.Query()
+	q.Set(audienceParam, t.audience)
+	req.URL.RawQuery = q.Encode()
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,123 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTLogin(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		audience     string
+		requiredType string
+		methodType   string
+		expAudience  string
+		expErr       string
+	}{
+		"audience": {
+			audience:    "consul",
+			expAudience: "consul",
+		},
+		"no audience": {},
+		"type checked": {
+			audience:     "consul",
+			requiredType: "jwt",
+			methodType:   "jwt",
+			expAudience:  "consul",
+		},
+		"kubernetes auth method": {
+			audience:     "consul",
+			requiredType: "jwt",
+			methodType:   "kubernetes",
+			expErr:       `auth method "consul-k8s-auth-method" has type "kubernetes" but "jwt" is required`,
+		},
+		"kubernetes required": {
+			requiredType: "kubernetes",
+			expErr:       `RequiredAuthMethodType must be "jwt" for a JWT login, not "kubernetes"`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var audience, bearerToken string
+			var logins int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v1/acl/auth-method/" + testAuthMethod:
+					// Login tokens can't read auth methods, so the type is
+					// only available if the test opted in to checking it.
+					if c.methodType == "" {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+					w.Write([]byte(`{"Name":"` + testAuthMethod + `","Type":"` + c.methodType + `"}`))
+				case "/v1/acl/login":
+					logins++
+					audience = r.URL.Query().Get("audience")
+					var params api.ACLLoginParams
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+					bearerToken = params.BearerToken
+					w.Write([]byte(testLoginResponse))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			t.Cleanup(server.Close)
+			client, err := ConsulClientWithOptions(&api.Config{Address: server.URL}, ClientOptions{Audience: c.audience})
+			require.NoError(t, err)
+
+			_, err = JWTLogin(LoginConfig{
+				Client:                 client,
+				BearerTokenFile:        WriteTempFile(t, "header.payload.signature"),
+				AuthMethod:             testAuthMethod,
+				RequiredAuthMethodType: c.requiredType,
+				TokenSinkFile:          WriteTempFile(t, ""),
+				Meta:                   testPodMeta,
+			})
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				require.Zero(t, logins)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expAudience, audience)
+			require.Equal(t, "header.payload.signature", bearerToken)
+		})
+	}
+}
+
+// TestClientOptions_AudienceOnlyOnLogin ensures that the audience is only
+// sent with logins and not with the client's other requests.
+func TestClientOptions_AudienceOnlyOnLogin(t *testing.T) {
+	t.Parallel()
+	audiences := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audiences[r.URL.Path] = r.URL.Query().Get("audience")
+		switch r.URL.Path {
+		case "/v1/acl/login":
+			w.Write([]byte(testLoginResponse))
+		case "/v1/status/leader":
+			w.Write([]byte(`"127.0.0.1:8300"`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client, err := ConsulClientWithOptions(&api.Config{Address: server.URL}, ClientOptions{Audience: "consul"})
+	require.NoError(t, err)
+
+	_, err = client.Status().Leader()
+	require.NoError(t, err)
+	_, err = ConsulLogin(testLoginConfig(t, client))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"/v1/status/leader": "",
+		"/v1/acl/login":     "consul",
+	}, audiences)
+}
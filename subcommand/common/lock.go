@@ -0,0 +1,25 @@
+package common
+
+import (
+	"fmt"
+	"os"
+)
+
+// AcquireFileLock takes an exclusive, non-blocking lock on the file at path,
+// creating it if needed. It returns an error straight away if another process,
+// or another call in this process, already holds the lock. The returned
+// release func unlocks and closes the file.
+func AcquireFileLock(path string) (release func(), err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lock file: %s", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to acquire lock on %s: %s", path, err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package common
+
+import (
+	"errors"
+	"os"
+)
+
+func lockFile(*os.File) error {
+	return errors.New("file locking is not supported on this platform")
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
@@ -0,0 +1,50 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package common
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireFileLock(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "login.lock")
+
+	release, err := AcquireFileLock(path)
+	require.NoError(err)
+
+	// A second acquisition should fail while the first holds the lock.
+	_, err = AcquireFileLock(path)
+	require.Error(err)
+	require.Contains(err.Error(), "unable to acquire lock on "+path)
+
+	// Once released, the lock can be acquired again.
+	release()
+	release, err = AcquireFileLock(path)
+	require.NoError(err)
+	release()
+}
+
+func TestRunLoginLoop_LockHeld(t *testing.T) {
+	t.Parallel()
+	lockFile := filepath.Join(t.TempDir(), "login.lock")
+	release, err := AcquireFileLock(lockFile)
+	require.NoError(t, err)
+	defer release()
+
+	counter := 0
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cfg := testLoginConfig(t, startMockServer(t, &counter))
+	cfg.LockFile = lockFile
+	RunLoginLoop(ctx, cfg, time.Second)
+	require.NoError(t, ctx.Err(), "loop should return straight away")
+	require.Equal(t, 0, counter)
+}
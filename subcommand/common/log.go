@@ -0,0 +1,58 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// warnedKeys holds the keys WarnOnce has already logged.
+var warnedKeys sync.Map
+
+// WarnOnce logs msg as a warning the first time it is called with key and
+// does nothing on later calls with the same key for the rest of the process.
+// It is meant for warnings that would otherwise repeat on every iteration of
+// a loop.
+func WarnOnce(logger hclog.Logger, key, msg string, args ...interface{}) {
+	if _, loaded := warnedKeys.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	logger.Warn(msg, args...)
+}
+
+// EnvoyLogLevel converts one of our log levels (as passed to -log-level) to
+// the equivalent value for Envoy's --log-level flag.
+func EnvoyLogLevel(level string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "trace":
+		return "trace", nil
+	case "debug":
+		return "debug", nil
+	case "info":
+		return "info", nil
+	case "warn":
+		return "warning", nil
+	case "error":
+		return "error", nil
+	default:
+		return "", fmt.Errorf("unknown log level: %s", level)
+	}
+}
+
+// TruncateForLog returns s cut to at most max bytes, followed by a note of how
+// many bytes were cut, so that large values such as response bodies don't
+// produce huge log lines. s is never cut in the middle of a UTF-8 character.
+// If max is zero or negative, s is returned as is.
+func TruncateForLog(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s... (%d bytes truncated)", s[:cut], len(s)-cut)
+}
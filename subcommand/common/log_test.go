@@ -0,0 +1,97 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarnOnce(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &buf})
+
+	for i := 0; i < 3; i++ {
+		WarnOnce(logger, "TestWarnOnce/skew", "clock skew detected", "skew", "5s")
+	}
+	WarnOnce(logger, "TestWarnOnce/other", "something else")
+
+	require.Equal(t, 1, strings.Count(buf.String(), "clock skew detected: skew=5s"))
+	require.Equal(t, 1, strings.Count(buf.String(), "something else"))
+}
+
+func TestEnvoyLogLevel(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"trace": "trace",
+		"debug": "debug",
+		"info":  "info",
+		"warn":  "warning",
+		"error": "error",
+		"WARN":  "warning",
+	}
+	for level, expected := range cases {
+		envoyLevel, err := EnvoyLogLevel(level)
+		require.NoError(t, err)
+		require.Equal(t, expected, envoyLevel, "level %s", level)
+	}
+}
+
+func TestEnvoyLogLevel_Invalid(t *testing.T) {
+	t.Parallel()
+	_, err := EnvoyLogLevel("verbose")
+	require.EqualError(t, err, "unknown log level: verbose")
+}
+
+func TestTruncateForLog(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		s   string
+		max int
+		exp string
+	}{
+		"under the limit": {s: "short", max: 10, exp: "short"},
+		"at the limit":    {s: "exactly10!", max: 10, exp: "exactly10!"},
+		"over the limit":  {s: "a somewhat longer body", max: 10, exp: "a somewhat... (12 bytes truncated)"},
+		"no limit":        {s: "a somewhat longer body", max: 0, exp: "a somewhat longer body"},
+		"multi-byte rune": {s: "héllo", max: 2, exp: "h... (5 bytes truncated)"},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, c.exp, TruncateForLog(c.s, c.max))
+		})
+	}
+}
+
+func TestConsulLogin_LogsResponse(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		maxLogBytes int
+		expContains string
+	}{
+		"default":   {expContains: "926e2bd2-b344-d91b-0c83-ae89f372cd9b"},
+		"truncated": {maxLogBytes: 30, expContains: "bytes truncated"},
+		"no limit":  {maxLogBytes: -1, expContains: "ModifyIndex"},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var logs bytes.Buffer
+			counter := 0
+			cfg := testLoginConfig(t, startMockServer(t, &counter))
+			cfg.Logger = hclog.New(&hclog.LoggerOptions{Output: &logs, Level: hclog.Debug})
+			cfg.MaxLogBytes = c.maxLogBytes
+			_, err := ConsulLogin(cfg)
+			require.NoError(t, err)
+			require.Contains(t, logs.String(), "Logged in to Consul")
+			require.Contains(t, logs.String(), c.expContains)
+			require.NotContains(t, logs.String(), "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586")
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// secretIDFormat matches the UUIDs Consul uses as ACL token secret IDs.
+var secretIDFormat = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// LoginResponse is the ACL token returned by Consul's /v1/acl/login endpoint.
+// Its fields and JSON encoding match the endpoint's response.
+type LoginResponse struct {
+	AccessorID        string
+	SecretID          string
+	Description       string
+	Policies          []*api.ACLTokenPolicyLink `json:",omitempty"`
+	Roles             []*api.ACLTokenRoleLink   `json:",omitempty"`
+	ServiceIdentities []*api.ACLServiceIdentity `json:",omitempty"`
+	NodeIdentities    []*api.ACLNodeIdentity    `json:",omitempty"`
+	Local             bool
+	AuthMethod        string        `json:",omitempty"`
+	ExpirationTTL     time.Duration `json:",omitempty"`
+	ExpirationTime    *time.Time    `json:",omitempty"`
+	CreateTime        time.Time
+	Hash              []byte
+	CreateIndex       uint64
+	ModifyIndex       uint64
+	Namespace         string `json:",omitempty"`
+}
+
+// newLoginResponse converts the token returned by the API client.
+func newLoginResponse(tok *api.ACLToken) *LoginResponse {
+	return &LoginResponse{
+		AccessorID:        tok.AccessorID,
+		SecretID:          tok.SecretID,
+		Description:       tok.Description,
+		Policies:          tok.Policies,
+		Roles:             tok.Roles,
+		ServiceIdentities: tok.ServiceIdentities,
+		NodeIdentities:    tok.NodeIdentities,
+		Local:             tok.Local,
+		AuthMethod:        tok.AuthMethod,
+		ExpirationTTL:     tok.ExpirationTTL,
+		ExpirationTime:    tok.ExpirationTime,
+		CreateTime:        tok.CreateTime,
+		Hash:              tok.Hash,
+		CreateIndex:       tok.CreateIndex,
+		ModifyIndex:       tok.ModifyIndex,
+		Namespace:         tok.Namespace,
+	}
+}
+
+// validateSecretID returns an error if secretID, from a successful login,
+// isn't a usable token. It guards against writing a malformed login response
+// to the sink.
+func validateSecretID(secretID string) error {
+	if secretID == "" {
+		return errors.New("login succeeded but returned empty token")
+	}
+	if !secretIDFormat.MatchString(secretID) {
+		return errors.New("login succeeded but returned a malformed token")
+	}
+	return nil
+}
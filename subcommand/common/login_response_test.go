@@ -0,0 +1,89 @@
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginResponse_Unmarshal(t *testing.T) {
+	t.Parallel()
+	var resp LoginResponse
+	require.NoError(t, json.Unmarshal([]byte(testLoginResponse), &resp))
+	requireTestLoginResponse(t, &resp)
+}
+
+// TestConsulLogin_ReturnsLoginResponse ensures ConsulLogin returns the full
+// token from the login response and not just the SecretID.
+func TestConsulLogin_ReturnsLoginResponse(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	resp, err := ConsulLogin(testLoginConfig(t, startMockServer(t, &counter)))
+	require.NoError(t, err)
+	requireTestLoginResponse(t, resp)
+}
+
+// TestConsulLogin_InvalidSecretID ensures that a malformed login response
+// isn't written to the sink.
+func TestConsulLogin_InvalidSecretID(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		body   string
+		expErr string
+	}{
+		"missing": {
+			body:   `{"AccessorID": "926e2bd2-b344-d91b-0c83-ae89f372cd9b"}`,
+			expErr: "login succeeded but returned empty token",
+		},
+		"not a uuid": {
+			body:   `{"AccessorID": "926e2bd2-b344-d91b-0c83-ae89f372cd9b", "SecretID": "<html>"}`,
+			expErr: "login succeeded but returned a malformed token",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(c.body))
+			})
+
+			sink := WriteTempFile(t, "old-token")
+			_, err := ConsulLogin(LoginConfig{
+				Client:          client,
+				BearerTokenFile: WriteTempFile(t, "foo"),
+				AuthMethod:      testAuthMethod,
+				TokenSinkFile:   sink,
+				Meta:            testPodMeta,
+			})
+			require.EqualError(t, err, c.expErr)
+			data, err := ioutil.ReadFile(sink)
+			require.NoError(t, err)
+			require.Equal(t, "old-token", string(data))
+		})
+	}
+}
+
+// requireTestLoginResponse asserts that resp matches testLoginResponse.
+func requireTestLoginResponse(t *testing.T, resp *LoginResponse) {
+	t.Helper()
+	createTime, err := time.Parse(time.RFC3339Nano, "2019-04-29T10:08:08.404370762-05:00")
+	require.NoError(t, err)
+
+	require.Equal(t, "926e2bd2-b344-d91b-0c83-ae89f372cd9b", resp.AccessorID)
+	require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", resp.SecretID)
+	require.Equal(t, "token created via login", resp.Description)
+	require.Equal(t, []*api.ACLTokenRoleLink{{ID: "3356c67c-5535-403a-ad79-c1d5f9df8fc7", Name: "demo"}}, resp.Roles)
+	require.Equal(t, []*api.ACLServiceIdentity{{ServiceName: "example"}}, resp.ServiceIdentities)
+	require.True(t, resp.Local)
+	require.Equal(t, "minikube", resp.AuthMethod)
+	require.True(t, createTime.Equal(resp.CreateTime))
+	require.NotEmpty(t, resp.Hash)
+	require.Equal(t, uint64(36), resp.CreateIndex)
+	require.Equal(t, uint64(36), resp.ModifyIndex)
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Logout destroys the token ConsulLogin wrote to cfg's token sink so that it
+// can't be used after the process that owns it is gone. The token is read
+// back from the sink, so it only works with regular file sinks.
+func Logout(cfg LoginConfig) error {
+	if cfg.Client == nil {
+		return errors.New("no Consul client configured")
+	}
+	path := cfg.SinkPath()
+	if path == "" {
+		return errors.New("unable to read token to log out: the token sink is not a file")
+	}
+	if isFIFO(path) {
+		return fmt.Errorf("unable to read token to log out: %s is a named pipe", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read token to log out: %s", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return fmt.Errorf("no token to log out in %s", path)
+	}
+	if cfg.TokenEncryptionKeyEnv != "" {
+		key, err := tokenEncryptionKey(cfg.TokenEncryptionKeyEnv)
+		if err != nil {
+			return err
+		}
+		if token, err = ReadEncryptedToken(path, key); err != nil {
+			return err
+		}
+	}
+	return logout(cfg, token)
+}
+
+// logout destroys token, which must have been created by logging in with cfg.
+func logout(cfg LoginConfig, token string) error {
+	if _, err := cfg.Client.ACL().Logout(&api.WriteOptions{Token: token, Namespace: cfg.Namespace}); err != nil {
+		return fmt.Errorf("error logging out: %s", err)
+	}
+	return nil
+}
+
+// RunLoginLoopWithSignals runs RunLoginLoop until ctx is cancelled or the
+// process receives SIGINT or SIGTERM, then logs out of Consul on a best
+// effort basis before returning so the token doesn't outlive the pod. The
+// token of the last successful login is kept in memory for this, so it works
+// with any token sink.
+func RunLoginLoopWithSignals(ctx context.Context, cfg LoginConfig, every time.Duration) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	runLoginLoopUntilSignal(ctx, cfg, every, sigCh)
+}
+
+// runLoginLoopUntilSignal is RunLoginLoopWithSignals with the signal channel
+// passed in so tests can trigger the shutdown.
+func runLoginLoopUntilSignal(ctx context.Context, cfg LoginConfig, every time.Duration, sigCh <-chan os.Signal) {
+	logger := cfg.logger()
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var secretID string
+	go func() {
+		defer close(done)
+		secretID = runLoginLoop(ctx, cfg, every)
+	}()
+	select {
+	case sig := <-sigCh:
+		logger.Info("Received signal; stopping login loop", "signal", sig)
+	case <-ctx.Done():
+	}
+	cancel()
+	<-done
+	if secretID == "" {
+		logger.Info("Not logging out of Consul; no login succeeded")
+		return
+	}
+	if err := logout(cfg, secretID); err != nil {
+		logger.Warn("Unable to log out of Consul", "error", err)
+		return
+	}
+	logger.Info("Logged out of Consul")
+}
@@ -0,0 +1,27 @@
+package common
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestRunLoginLoopWithSignals_FIFOSink ensures that shutting down doesn't
+// block on reading the token back from a named pipe nobody writes to.
+func TestRunLoginLoopWithSignals_FIFOSink(t *testing.T) {
+	t.Parallel()
+	fifo := filepath.Join(t.TempDir(), "acl-token")
+	require.NoError(t, syscall.Mkfifo(fifo, 0600))
+	// Read the token the login writes since writes block until there's a
+	// reader.
+	go ioutil.ReadFile(fifo)
+
+	token := runLoginLoopUntilSIGTERM(t, func(cfg LoginConfig) LoginConfig {
+		cfg.TokenSinkFile = fifo
+		return cfg
+	})
+	require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", token)
+}
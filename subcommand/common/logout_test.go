@@ -0,0 +1,104 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunLoginLoopWithSignals_LogsOutOnSignal(t *testing.T) {
+	t.Parallel()
+	token := runLoginLoopUntilSIGTERM(t, func(cfg LoginConfig) LoginConfig {
+		cfg.TokenSinkFile = WriteTempFile(t, "")
+		return cfg
+	})
+	require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", token)
+}
+
+// TestRunLoginLoopWithSignals_VaultSink ensures that the token is logged out
+// even though it can't be read back from the sink.
+func TestRunLoginLoopWithSignals_VaultSink(t *testing.T) {
+	t.Parallel()
+	vault := startMockVault(t)
+	token := runLoginLoopUntilSIGTERM(t, func(cfg LoginConfig) LoginConfig {
+		cfg.TokenSink = VaultSink{Address: vault.URL, Token: "vault-token", Path: "secret/consul-token"}
+		return cfg
+	})
+	require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", token)
+}
+
+// runLoginLoopUntilSIGTERM runs a login loop configured by configure against
+// a mock Consul, sends it SIGTERM once it has logged in and returns the token
+// it then logged out.
+func runLoginLoopUntilSIGTERM(t *testing.T, configure func(LoginConfig) LoginConfig) string {
+	t.Helper()
+	var logins int32
+	logoutToken := make(chan string, 1)
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/acl/login":
+			atomic.AddInt32(&logins, 1)
+			w.Write([]byte(testLoginResponse))
+		case "/v1/acl/logout":
+			logoutToken <- r.Header.Get("X-Consul-Token")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	clock := NewFakeClock(time.Now())
+	sigCh := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	cfg := configure(LoginConfig{
+		Client:          client,
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		Meta:            testPodMeta,
+		Clock:           clock,
+	})
+	go func() {
+		defer close(done)
+		runLoginLoopUntilSignal(context.Background(), cfg, time.Hour, sigCh)
+	}()
+
+	require.Eventually(t, func() bool {
+		return clock.Waiters() == 1 && atomic.LoadInt32(&logins) == 1
+	}, 5*time.Second, time.Millisecond)
+	sigCh <- syscall.SIGTERM
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the login loop to stop")
+	}
+	select {
+	case token := <-logoutToken:
+		return token
+	default:
+		t.Fatal("no logout request was made")
+		return ""
+	}
+}
+
+func TestLogout_NoToken(t *testing.T) {
+	t.Parallel()
+	client, err := api.NewClient(&api.Config{Address: "127.0.0.1:1"})
+	require.NoError(t, err)
+	sink := WriteTempFile(t, "")
+	err = Logout(LoginConfig{Client: client, TokenSinkFile: sink})
+	require.EqualError(t, err, "no token to log out in "+sink)
+}
+
+func TestLogout_NotAFileSink(t *testing.T) {
+	t.Parallel()
+	client, err := api.NewClient(&api.Config{Address: "127.0.0.1:1"})
+	require.NoError(t, err)
+	err = Logout(LoginConfig{Client: client, TokenSink: VaultSink{Path: "secret/consul-token"}})
+	require.EqualError(t, err, "unable to read token to log out: the token sink is not a file")
+}
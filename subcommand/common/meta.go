@@ -0,0 +1,26 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// podMetaKey is the login meta key holding the pod's <namespace>/<name>.
+	podMetaKey = "pod"
+	// restartCountMetaKey is the login meta key holding the number of times
+	// the container has been restarted.
+	restartCountMetaKey = "restart-count"
+)
+
+// PodLoginMeta returns the meta to log in with for the pod podName in
+// podNamespace. If restartCount is not empty, usually because it is exposed
+// through the Downward API, it is included too so that tokens can be
+// correlated with container restarts.
+func PodLoginMeta(podNamespace, podName, restartCount string) map[string]string {
+	meta := map[string]string{podMetaKey: fmt.Sprintf("%s/%s", podNamespace, podName)}
+	if restartCount = strings.TrimSpace(restartCount); restartCount != "" {
+		meta[restartCountMetaKey] = restartCount
+	}
+	return meta
+}
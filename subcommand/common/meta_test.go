@@ -0,0 +1,13 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPodLoginMeta(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, map[string]string{"pod": "default/pod"}, PodLoginMeta("default", "pod", ""))
+	require.Equal(t, map[string]string{"pod": "default/pod", "restart-count": "3"}, PodLoginMeta("default", "pod", "3"))
+}
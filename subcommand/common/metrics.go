@@ -0,0 +1,65 @@
+package common
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	loginOutcomeSuccess = "success"
+	loginOutcomeFailure = "failure"
+)
+
+// LoginMetrics records the latency and outcome of Consul logins. Set it on
+// LoginConfig.Metrics to have ConsulLogin record every login.
+type LoginMetrics struct {
+	duration prometheus.Histogram
+	outcomes *prometheus.CounterVec
+}
+
+// RegisterLoginMetrics creates the login metrics and registers them with reg.
+func RegisterLoginMetrics(reg prometheus.Registerer) (*LoginMetrics, error) {
+	m := &LoginMetrics{
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "consul_k8s",
+			Name:      "login_duration_seconds",
+			Help:      "Time taken to log in to Consul with an auth method.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		outcomes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "consul_k8s",
+			Name:      "login_total",
+			Help:      "Number of logins to Consul with an auth method, by outcome.",
+		}, []string{"outcome"}),
+	}
+	for _, c := range []prometheus.Collector{m.duration, m.outcomes} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// observe records a login that took elapsed and finished with err.
+func (m *LoginMetrics) observe(elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.duration.Observe(elapsed.Seconds())
+	outcome := loginOutcomeSuccess
+	if err != nil {
+		outcome = loginOutcomeFailure
+	}
+	m.outcomes.WithLabelValues(outcome).Inc()
+}
+
+// MetricsServer returns a server for -metrics-addr that serves the metrics
+// gathered by g on /metrics. The caller is responsible for starting it.
+func MetricsServer(addr string, g prometheus.Gatherer) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+	return &http.Server{Addr: addr, Handler: mux}
+}
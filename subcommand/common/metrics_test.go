@@ -0,0 +1,54 @@
+package common
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterLoginMetrics(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	reg := prometheus.NewRegistry()
+	metrics, err := RegisterLoginMetrics(reg)
+	require.NoError(err)
+
+	counter := 0
+	cfg := testLoginConfig(t, startMockServer(t, &counter))
+	cfg.Metrics = metrics
+	_, err = ConsulLogin(cfg)
+	require.NoError(err)
+	// A failed login.
+	_, err = ConsulLogin(LoginConfig{
+		BearerTokenFile: WriteTempFile(t, ""),
+		AuthMethod:      testAuthMethod,
+		Meta:            testPodMeta,
+		Metrics:         metrics,
+	})
+	require.Error(err)
+
+	server := httptest.NewServer(MetricsServer("", reg).Handler)
+	t.Cleanup(server.Close)
+	resp, err := server.Client().Get(server.URL + "/metrics")
+	require.NoError(err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(err)
+
+	require.Contains(string(body), "consul_k8s_login_duration_seconds_count 2")
+	require.Contains(string(body), `consul_k8s_login_total{outcome="success"} 1`)
+	require.Contains(string(body), `consul_k8s_login_total{outcome="failure"} 1`)
+}
+
+func TestRegisterLoginMetrics_AlreadyRegistered(t *testing.T) {
+	t.Parallel()
+	reg := prometheus.NewRegistry()
+	_, err := RegisterLoginMetrics(reg)
+	require.NoError(t, err)
+	_, err = RegisterLoginMetrics(reg)
+	require.Error(t, err)
+}
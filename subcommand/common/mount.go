@@ -0,0 +1,120 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// tmpfsMagic is the filesystem type reported by statfs for tmpfs, which
+	// is what backs emptyDir volumes with medium Memory as well as projected
+	// and secret volumes.
+	tmpfsMagic = 0x01021994
+	// ramfsMagic is the filesystem type reported by statfs for ramfs.
+	ramfsMagic = 0x858458f6
+
+	// mountInfoPath lists the mounts visible to this process.
+	mountInfoPath = "/proc/self/mountinfo"
+	// kubeletVolumesDir is part of the source path of the volumes the kubelet
+	// manages itself, such as emptyDir, secret and projected volumes, as
+	// opposed to hostPath volumes.
+	kubeletVolumesDir = "/volumes/kubernetes.io~"
+)
+
+// IsEphemeralMount returns true if path is on an in-memory filesystem, meaning
+// its contents won't outlive the pod. It is used to warn when ACL tokens are
+// written somewhere they could persist.
+func IsEphemeralMount(path string) (bool, error) {
+	return isEphemeralMount(path, statfsType)
+}
+
+func isEphemeralMount(path string, fsType func(string) (int64, error)) (bool, error) {
+	t, err := fsType(path)
+	if err != nil {
+		return false, fmt.Errorf("unable to determine filesystem type of %s: %s", path, err)
+	}
+	return t == tmpfsMagic || t == ramfsMagic, nil
+}
+
+// AssertNotHostPath returns true if path is in the container's own
+// filesystem or a volume managed by the kubelet, and false if it is on a
+// directory bind mounted from the host, such as a hostPath volume. Writing an
+// ACL token there would leave it on the node. The check is a heuristic based
+// on where the mount holding path comes from, as listed in
+// /proc/self/mountinfo, so it only works on Linux.
+func AssertNotHostPath(path string) (bool, error) {
+	return assertNotHostPath(path, readMountInfo)
+}
+
+func readMountInfo() ([]byte, error) {
+	return ioutil.ReadFile(mountInfoPath)
+}
+
+func assertNotHostPath(path string, mountInfo func() ([]byte, error)) (bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	data, err := mountInfo()
+	if err != nil {
+		return false, fmt.Errorf("unable to read mounts: %s", err)
+	}
+	// Find the mount path is on, which is the one with the longest mount
+	// point containing it.
+	var root, mountPoint string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// The fields are described in proc(5). The root of the mount within
+		// its filesystem is the fourth and the mount point the fifth.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mp := unescapeMountField(fields[4])
+		if len(mp) < len(mountPoint) || !pathContains(mp, abs) {
+			continue
+		}
+		root, mountPoint = unescapeMountField(fields[3]), mp
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("unable to read mounts: %s", err)
+	}
+	if mountPoint == "" {
+		return false, fmt.Errorf("unable to find the mount %s is on", path)
+	}
+	// Mounts of a whole filesystem, such as the container's root filesystem,
+	// tmpfs and persistent volumes, have "/" as their root. Anything else is a
+	// bind mount of a directory, which is fine if the kubelet manages it.
+	return root == "/" || strings.Contains(root, kubeletVolumesDir), nil
+}
+
+// pathContains returns true if path is dir or is inside it. Both must be
+// clean absolute paths.
+func pathContains(dir, path string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// unescapeMountField undoes the octal escaping of spaces, tabs, newlines and
+// backslashes in the fields of /proc/self/mountinfo.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,15 @@
+//go:build linux
+// +build linux
+
+package common
+
+import "syscall"
+
+// statfsType returns the filesystem type of path as reported by statfs(2).
+func statfsType(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Type), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package common
+
+import "errors"
+
+// statfsType is only implemented on Linux, which is where our images run.
+func statfsType(string) (int64, error) {
+	return 0, errors.New("filesystem type lookup is only supported on linux")
+}
@@ -0,0 +1,187 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsEphemeralMount(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		fsType    int64
+		err       error
+		expected  bool
+		expErrStr string
+	}{
+		"tmpfs": {
+			fsType:   tmpfsMagic,
+			expected: true,
+		},
+		"ramfs": {
+			fsType:   ramfsMagic,
+			expected: true,
+		},
+		"ext4": {
+			fsType:   0xef53,
+			expected: false,
+		},
+		"lookup error": {
+			err:       errors.New("no such file or directory"),
+			expErrStr: "unable to determine filesystem type of /sink: no such file or directory",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			ephemeral, err := isEphemeralMount("/sink", func(string) (int64, error) {
+				return c.fsType, c.err
+			})
+			if c.expErrStr != "" {
+				require.EqualError(t, err, c.expErrStr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, ephemeral)
+		})
+	}
+}
+
+// TestConsulLogin_WarnsOnPersistentSink ensures that ConsulLogin warns only
+// when the token sink is not on an in-memory filesystem.
+func TestConsulLogin_WarnsOnPersistentSink(t *testing.T) {
+	t.Parallel()
+	for _, fsType := range []int64{tmpfsMagic, 0xef53} {
+		var buf bytes.Buffer
+		counter := 0
+		cfg := testLoginConfig(t, startMockServer(t, &counter))
+		cfg.Logger = hclog.New(&hclog.LoggerOptions{Output: &buf})
+		cfg.fsType = func(string) (int64, error) { return fsType, nil }
+		_, err := ConsulLogin(cfg)
+		require.NoError(t, err)
+		if fsType == tmpfsMagic {
+			require.NotContains(t, buf.String(), "persistent volume")
+		} else {
+			require.Contains(t, buf.String(), "[WARN]  ACL token is being written to a persistent volume")
+		}
+	}
+}
+
+// TestConsulLogin_WarnsOnPersistentSinkOnce ensures that repeated logins to
+// the same persistent sink, as done by RunLoginLoop, only warn once.
+func TestConsulLogin_WarnsOnPersistentSinkOnce(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	counter := 0
+	cfg := testLoginConfig(t, startMockServer(t, &counter))
+	cfg.Logger = hclog.New(&hclog.LoggerOptions{Output: &buf})
+	cfg.fsType = func(string) (int64, error) { return 0xef53, nil }
+	for i := 0; i < 3; i++ {
+		_, err := ConsulLogin(cfg)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, counter)
+	require.Equal(t, 1, strings.Count(buf.String(), "persistent volume"))
+}
+
+// testMountInfo is a /proc/self/mountinfo as seen from inside a container.
+const testMountInfo = `1 0 0:50 / / rw,relatime - overlay overlay rw,lowerdir=/l,upperdir=/u,workdir=/w
+2 1 8:1 /var/lib/kubelet/pods/1234/volumes/kubernetes.io~empty-dir/sink /consul/login rw,relatime - ext4 /dev/sda1 rw
+3 1 8:1 /data/consul /consul/host rw,relatime - ext4 /dev/sda1 rw
+4 1 0:60 / /consul/host/tmp rw,relatime - tmpfs tmpfs rw
+5 1 8:1 /data/with\040space /consul/with\040space rw,relatime - ext4 /dev/sda1 rw
+`
+
+func TestAssertNotHostPath(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		path      string
+		mountInfo string
+		err       error
+		expected  bool
+		expErrStr string
+	}{
+		"container filesystem": {
+			path:      "/tmp/acl-token",
+			mountInfo: testMountInfo,
+			expected:  true,
+		},
+		"emptyDir": {
+			path:      "/consul/login/acl-token",
+			mountInfo: testMountInfo,
+			expected:  true,
+		},
+		"hostPath": {
+			path:      "/consul/host/acl-token",
+			mountInfo: testMountInfo,
+			expected:  false,
+		},
+		"tmpfs nested in a hostPath": {
+			path:      "/consul/host/tmp/acl-token",
+			mountInfo: testMountInfo,
+			expected:  true,
+		},
+		"mount point prefix of another directory": {
+			path:      "/consul/hostile/acl-token",
+			mountInfo: testMountInfo,
+			expected:  true,
+		},
+		"escaped mount point": {
+			path:      "/consul/with space/acl-token",
+			mountInfo: testMountInfo,
+			expected:  false,
+		},
+		"no mounts": {
+			path:      "/consul/login/acl-token",
+			mountInfo: "",
+			expErrStr: "unable to find the mount /consul/login/acl-token is on",
+		},
+		"read error": {
+			path:      "/consul/login/acl-token",
+			err:       errors.New("no such file or directory"),
+			expErrStr: "unable to read mounts: no such file or directory",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			ok, err := assertNotHostPath(c.path, func() ([]byte, error) {
+				return []byte(c.mountInfo), c.err
+			})
+			if c.expErrStr != "" {
+				require.EqualError(t, err, c.expErrStr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, ok)
+		})
+	}
+}
+
+func TestConsulLogin_ForbidHostPathSink(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	client := startMockServer(t, &counter)
+	sink := WriteTempFile(t, "")
+	hostMount := fmt.Sprintf("1 0 0:50 / / rw - overlay overlay rw\n2 1 8:1 /data %s rw - ext4 /dev/sda1 rw\n", filepath.Dir(sink))
+
+	_, err := ConsulLogin(LoginConfig{
+		Client:             client,
+		BearerTokenFile:    WriteTempFile(t, "foo"),
+		AuthMethod:         testAuthMethod,
+		TokenSinkFile:      sink,
+		Meta:               testPodMeta,
+		ForbidHostPathSink: true,
+		mountInfo: func() ([]byte, error) {
+			return []byte(hostMount), nil
+		},
+	})
+	require.EqualError(t, err, fmt.Sprintf("token sink %s is on a volume mounted from the host", sink))
+	require.Zero(t, counter)
+}
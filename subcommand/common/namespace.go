@@ -0,0 +1,190 @@
+package common
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// namespaceMaxLength is the maximum length of a namespace name allowed by
+// Consul.
+const namespaceMaxLength = 64
+
+var (
+	// validNamespace matches the namespace names Consul accepts.
+	validNamespace = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+	// invalidNamespaceChars matches characters Consul does not accept in
+	// namespace names.
+	invalidNamespaceChars = regexp.MustCompile(`[^a-zA-Z0-9-]`)
+)
+
+// ValidateNamespace returns an error if Consul would reject ns as a namespace
+// name. An empty ns is valid and means no namespace. The error suggests the
+// name SanitizeNamespace makes of ns.
+func ValidateNamespace(ns string) error {
+	return withNamespaceSuggestion(ns, validateName("namespace", ns))
+}
+
+// withNamespaceSuggestion adds the name SanitizeNamespace makes of ns to err,
+// if any. It is only suggested since logging in to another namespace than the
+// configured one would be worse than failing.
+func withNamespaceSuggestion(ns string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if sanitized := SanitizeNamespace(ns); sanitized != "" && sanitized != ns {
+		return fmt.Errorf("%s; did you mean %q?", err, sanitized)
+	}
+	return err
+}
+
+// defaultName is the name of the partition and of the namespace that always
+// exist. Empty partition and namespace names refer to them.
+const defaultName = "default"
+
+// PartitionOption configures ValidatePartitionNamespace.
+type PartitionOption func(*partitionOptions)
+
+type partitionOptions struct {
+	// restrictDefaultPartition is true if only the default namespace may be
+	// used in the default partition.
+	restrictDefaultPartition bool
+}
+
+// RestrictDefaultPartition makes ValidatePartitionNamespace only accept the
+// default namespace in the default partition, for clusters where the
+// default partition is reserved for the Consul servers and shared services.
+func RestrictDefaultPartition() PartitionOption {
+	return func(o *partitionOptions) { o.restrictDefaultPartition = true }
+}
+
+// ValidatePartitionNamespace returns an error if Consul would reject the
+// combination of the admin partition partition and the namespace ns. Both
+// must be valid names. Empty values mean the default partition and the
+// default namespace, which always exist. Further restrictions on the
+// combination depend on how the cluster is set up, so they are enabled with
+// opts.
+func ValidatePartitionNamespace(partition, ns string, opts ...PartitionOption) error {
+	var o partitionOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := validateName("partition", partition); err != nil {
+		return err
+	}
+	if err := validateName("namespace", ns); err != nil {
+		if partition != "" {
+			err = fmt.Errorf("%s in partition %q", err, partition)
+		}
+		return withNamespaceSuggestion(ns, err)
+	}
+	isDefault := func(name string) bool { return name == "" || name == defaultName }
+	if o.restrictDefaultPartition && isDefault(partition) && !isDefault(ns) {
+		return fmt.Errorf("namespace %q cannot be used in the default partition, which is restricted to the default namespace", ns)
+	}
+	return nil
+}
+
+// validateName returns an error if Consul would reject name as the name of a
+// kind, such as a namespace or partition. Both follow the same rules.
+func validateName(kind, name string) error {
+	switch {
+	case name == "":
+		return nil
+	case len(name) > namespaceMaxLength:
+		return fmt.Errorf("%s %q is longer than %d characters", kind, name, namespaceMaxLength)
+	case !validNamespace.MatchString(name):
+		return fmt.Errorf("%s %q may only contain alphanumeric characters and dashes and must start and end with an alphanumeric character", kind, name)
+	}
+	return nil
+}
+
+// SanitizeNamespace replaces characters Consul does not allow in namespace
+// names with dashes, trims leading and trailing dashes and truncates ns to the
+// maximum length Consul allows.
+func SanitizeNamespace(ns string) string {
+	ns = strings.Trim(invalidNamespaceChars.ReplaceAllString(ns, "-"), "-")
+	if len(ns) > namespaceMaxLength {
+		ns = strings.TrimRight(ns[:namespaceMaxLength], "-")
+	}
+	return ns
+}
+
+// maxTrackedTokens is the number of bearer tokens loginNamespaces remembers.
+// Projected service account tokens are rotated, so long running processes
+// see an unbounded number of them over time.
+const maxTrackedTokens = 128
+
+// loginNamespaces records the namespaces bearer tokens have been used to log
+// in to by this process.
+var loginNamespaces = newNamespaceTracker(maxTrackedTokens)
+
+// namespaceTracker records the namespaces each bearer token is used with. It
+// only keeps a hash of the tokens and forgets the least recently used ones
+// once it tracks more than max of them. It is safe for concurrent use.
+type namespaceTracker struct {
+	mu  sync.Mutex
+	max int
+	// lru holds the *trackedToken of each token, most recently used first.
+	lru  *list.List
+	seen map[[sha256.Size]byte]*list.Element
+}
+
+// trackedToken is the hash of a bearer token and the namespaces it was used
+// with.
+type trackedToken struct {
+	key        [sha256.Size]byte
+	namespaces map[string]struct{}
+}
+
+func newNamespaceTracker(max int) *namespaceTracker {
+	return &namespaceTracker{
+		max:  max,
+		lru:  list.New(),
+		seen: make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// record records that bearerToken is used with namespace and returns the
+// namespaces it was used with before if namespace isn't one of them.
+func (t *namespaceTracker) record(bearerToken, namespace string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := sha256.Sum256([]byte(bearerToken))
+	elem, ok := t.seen[key]
+	if ok {
+		t.lru.MoveToFront(elem)
+	} else {
+		elem = t.lru.PushFront(&trackedToken{key: key, namespaces: make(map[string]struct{})})
+		t.seen[key] = elem
+		if t.lru.Len() > t.max {
+			oldest := t.lru.Remove(t.lru.Back()).(*trackedToken)
+			delete(t.seen, oldest.key)
+		}
+	}
+	namespaces := elem.Value.(*trackedToken).namespaces
+	if _, ok := namespaces[namespace]; ok {
+		return nil
+	}
+	var others []string
+	for ns := range namespaces {
+		others = append(others, ns)
+	}
+	sort.Strings(others)
+	namespaces[namespace] = struct{}{}
+	return others
+}
+
+// warnIfTokenReused logs a warning if bearerToken has already been used by
+// this process to log in to a namespace other than cfg.Namespace, which
+// usually means that the namespace of one of the logins is misconfigured.
+func warnIfTokenReused(cfg LoginConfig, bearerToken string) {
+	if others := loginNamespaces.record(bearerToken, cfg.Namespace); len(others) > 0 {
+		cfg.logger().Warn("The same bearer token is used to log in to more than one namespace; check the namespace is configured correctly",
+			"namespace", cfg.Namespace, "other-namespaces", strings.Join(others, ","))
+	}
+}
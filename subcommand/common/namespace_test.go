@@ -0,0 +1,196 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateNamespace(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		ns     string
+		expErr string
+	}{
+		"empty":       {ns: ""},
+		"valid":       {ns: "team-a"},
+		"single char": {ns: "a"},
+		"max length":  {ns: strings.Repeat("a", 64)},
+		"invalid char": {
+			ns:     "team_a",
+			expErr: `namespace "team_a" may only contain alphanumeric characters and dashes and must start and end with an alphanumeric character; did you mean "team-a"?`,
+		},
+		"leading dash": {
+			ns:     "-team",
+			expErr: `namespace "-team" may only contain alphanumeric characters and dashes and must start and end with an alphanumeric character; did you mean "team"?`,
+		},
+		"too long": {
+			ns:     strings.Repeat("a", 65),
+			expErr: `namespace "` + strings.Repeat("a", 65) + `" is longer than 64 characters; did you mean "` + strings.Repeat("a", 64) + `"?`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateNamespace(c.ns)
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
+
+func TestValidatePartitionNamespace(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		partition string
+		ns        string
+		opts      []PartitionOption
+		expErr    string
+	}{
+		"defaults":                   {},
+		"namespace only":             {ns: "team-a"},
+		"partition only":             {partition: "eu-west"},
+		"default partition":          {partition: "default", ns: "default"},
+		"partition and namespace":    {partition: "eu-west", ns: "team-a"},
+		"default namespace in other": {partition: "eu-west", ns: "default"},
+		"invalid partition": {
+			partition: "eu_west",
+			ns:        "team-a",
+			expErr:    `partition "eu_west" may only contain alphanumeric characters and dashes and must start and end with an alphanumeric character`,
+		},
+		"partition too long": {
+			partition: strings.Repeat("a", 65),
+			expErr:    `partition "` + strings.Repeat("a", 65) + `" is longer than 64 characters`,
+		},
+		"invalid namespace in partition": {
+			partition: "eu-west",
+			ns:        "team_a",
+			expErr:    `namespace "team_a" may only contain alphanumeric characters and dashes and must start and end with an alphanumeric character in partition "eu-west"; did you mean "team-a"?`,
+		},
+		"invalid namespace without partition": {
+			ns:     "-team",
+			expErr: `namespace "-team" may only contain alphanumeric characters and dashes and must start and end with an alphanumeric character; did you mean "team"?`,
+		},
+		"restricted: defaults": {
+			opts: []PartitionOption{RestrictDefaultPartition()},
+		},
+		"restricted: default namespace in default partition": {
+			partition: "default",
+			ns:        "default",
+			opts:      []PartitionOption{RestrictDefaultPartition()},
+		},
+		"restricted: namespace in other partition": {
+			partition: "eu-west",
+			ns:        "team-a",
+			opts:      []PartitionOption{RestrictDefaultPartition()},
+		},
+		"restricted: namespace in default partition": {
+			partition: "default",
+			ns:        "team-a",
+			opts:      []PartitionOption{RestrictDefaultPartition()},
+			expErr:    `namespace "team-a" cannot be used in the default partition, which is restricted to the default namespace`,
+		},
+		"restricted: namespace without partition": {
+			ns:     "team-a",
+			opts:   []PartitionOption{RestrictDefaultPartition()},
+			expErr: `namespace "team-a" cannot be used in the default partition, which is restricted to the default namespace`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidatePartitionNamespace(c.partition, c.ns, c.opts...)
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
+
+func TestSanitizeNamespace(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		ns  string
+		exp string
+	}{
+		"valid":         {ns: "team-a", exp: "team-a"},
+		"invalid chars": {ns: "team_a.b", exp: "team-a-b"},
+		"edge chars":    {ns: "_team_", exp: "team"},
+		"too long":      {ns: strings.Repeat("a", 70), exp: strings.Repeat("a", 64)},
+		"dash at cut":   {ns: strings.Repeat("a", 63) + "_b", exp: strings.Repeat("a", 63)},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			out := SanitizeNamespace(c.ns)
+			require.Equal(t, c.exp, out)
+			require.NoError(t, ValidateNamespace(out))
+		})
+	}
+}
+
+func TestNamespaceTracker(t *testing.T) {
+	t.Parallel()
+	tracker := newNamespaceTracker(maxTrackedTokens)
+	require.Empty(t, tracker.record("token-a", "ns-1"))
+	require.Empty(t, tracker.record("token-a", "ns-1"))
+	require.Empty(t, tracker.record("token-b", "ns-2"))
+	require.Equal(t, []string{"ns-1"}, tracker.record("token-a", "ns-2"))
+	require.Equal(t, []string{"ns-1", "ns-2"}, tracker.record("token-a", "ns-3"))
+}
+
+func TestNamespaceTracker_EvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	tracker := newNamespaceTracker(2)
+	require.Empty(t, tracker.record("token-a", "ns-1"))
+	require.Empty(t, tracker.record("token-b", "ns-1"))
+	// Using token-a makes token-b the least recently used token.
+	require.Empty(t, tracker.record("token-a", "ns-1"))
+	require.Empty(t, tracker.record("token-c", "ns-1"))
+	require.Len(t, tracker.seen, 2)
+
+	// token-b was forgotten, token-a was not.
+	require.Empty(t, tracker.record("token-b", "ns-2"))
+	require.Equal(t, []string{"ns-1"}, tracker.record("token-c", "ns-2"))
+}
+
+func TestConsulLogin_WarnsOnTokenReuse(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	client := startMockServer(t, &counter)
+	// The token must be unique to this test because the namespaces tokens
+	// are used with are tracked for the whole process.
+	bearerTokenFile := WriteTempFile(t, "TestConsulLogin_WarnsOnTokenReuse")
+	var logs bytes.Buffer
+	login := func(namespace string) {
+		_, err := ConsulLogin(LoginConfig{
+			Client:          client,
+			BearerTokenFile: bearerTokenFile,
+			AuthMethod:      testAuthMethod,
+			TokenSinkFile:   WriteTempFile(t, ""),
+			Namespace:       namespace,
+			Meta:            testPodMeta,
+			Logger:          hclog.New(&hclog.LoggerOptions{Output: &logs}),
+		})
+		require.NoError(t, err)
+	}
+
+	login("ns-1")
+	login("ns-1")
+	require.NotContains(t, logs.String(), "more than one namespace")
+
+	login("ns-2")
+	require.Contains(t, logs.String(), "The same bearer token is used to log in to more than one namespace")
+	require.Contains(t, logs.String(), "namespace=ns-2 other-namespaces=ns-1")
+}
@@ -0,0 +1,26 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// WritePIDFile writes the PID of the current process to path for process
+// supervisors. The returned cleanup func removes the file, unless another
+// process has since replaced it with its own PID.
+func WritePIDFile(path string) (cleanup func(), err error) {
+	pid := strconv.Itoa(os.Getpid())
+	if err := WriteFileWithPerms(path, pid+"\n", 0644); err != nil {
+		return nil, fmt.Errorf("unable to write PID file: %s", err)
+	}
+	return func() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil || strings.TrimSpace(string(data)) != pid {
+			return
+		}
+		os.Remove(path)
+	}, nil
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePIDFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "consul-login.pid")
+	cleanup, err := WritePIDFile(path)
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%d\n", os.Getpid()), string(data))
+
+	cleanup()
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+// TestWritePIDFile_Replaced ensures that cleanup leaves alone a PID file that
+// another process has taken over.
+func TestWritePIDFile_Replaced(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "consul-login.pid")
+	cleanup, err := WritePIDFile(path)
+	require.NoError(t, err)
+	require.NoError(t, WriteFileWithPerms(path, "1\n", 0644))
+
+	cleanup()
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "1\n", string(data))
+}
+
+func TestRunLoginLoop_PIDFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "consul-login.pid")
+	counter := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := &recordingClock{FakeClock: NewFakeClock(time.Now()), max: 1, done: func() {
+		// The PID file exists while the loop runs.
+		_, err := os.Stat(path)
+		require.NoError(t, err)
+		cancel()
+	}}
+	cfg := testLoginConfig(t, startMockServer(t, &counter))
+	cfg.PIDFile = path
+	cfg.Clock = clock
+	RunLoginLoop(ctx, cfg, time.Hour)
+	_, err := os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PinnedTLSConfig returns a TLS config that trusts the PEM-encoded CA
+// certificates in caPEM and, in addition to the usual verification, fails
+// the handshake unless the server's certificate has the SHA-256 fingerprint
+// fingerprint. The fingerprint is hex encoded and may be separated by colons,
+// as printed by "openssl x509 -fingerprint -sha256".
+func PinnedTLSConfig(caPEM []byte, fingerprint string) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no valid certificates found in CA PEM")
+	}
+	pinned, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+	if err != nil || len(pinned) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", fingerprint)
+	}
+	return &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server did not present a certificate")
+			}
+			got := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if subtle.ConstantTimeCompare(got[:], pinned) != 1 {
+				return fmt.Errorf("server certificate fingerprint %x does not match the pinned fingerprint %x", got, pinned)
+			}
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinnedTLSConfig(t *testing.T) {
+	t.Parallel()
+	caPEM, certPEM, keyPEM := generateTestCerts(t)
+	block, _ := pem.Decode([]byte(certPEM))
+	require.NotNil(t, block)
+	sum := sha256.Sum256(block.Bytes)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	// colonFingerprint formats the fingerprint like openssl does.
+	var parts []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		parts = append(parts, strings.ToUpper(fingerprint[i:i+2]))
+	}
+	colonFingerprint := strings.Join(parts, ":")
+
+	cases := map[string]struct {
+		fingerprint string
+		expErr      string
+	}{
+		"matching fingerprint": {
+			fingerprint: fingerprint,
+		},
+		"matching fingerprint with colons": {
+			fingerprint: colonFingerprint,
+		},
+		"mismatched fingerprint": {
+			fingerprint: strings.Repeat("ab", sha256.Size),
+			expErr:      "server certificate fingerprint " + fingerprint + " does not match the pinned fingerprint " + strings.Repeat("ab", sha256.Size),
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			counter := 0
+			server := startMockTLSServer(t, certPEM, keyPEM, &counter)
+			tlsConfig, err := PinnedTLSConfig([]byte(caPEM), c.fingerprint)
+			require.NoError(t, err)
+			client, err := api.NewClient(&api.Config{
+				Address:    server.URL,
+				HttpClient: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+			})
+			require.NoError(t, err)
+
+			_, err = ConsulLogin(testLoginConfig(t, client))
+			if c.expErr == "" {
+				require.NoError(t, err)
+				require.Equal(t, 1, counter)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.expErr)
+			require.Zero(t, counter)
+		})
+	}
+}
+
+func TestPinnedTLSConfig_Invalid(t *testing.T) {
+	t.Parallel()
+	caPEM, _, _ := generateTestCerts(t)
+
+	_, err := PinnedTLSConfig([]byte("not a pem"), strings.Repeat("ab", sha256.Size))
+	require.EqualError(t, err, "no valid certificates found in CA PEM")
+
+	_, err = PinnedTLSConfig([]byte(caPEM), "abcd")
+	require.EqualError(t, err, `invalid SHA-256 fingerprint "abcd"`)
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package common
+
+import (
+	"errors"
+)
+
+// AssertReadableByUID is not supported on this platform and always returns
+// an error.
+func AssertReadableByUID(string, int) error {
+	return errors.New("checking file permissions by uid is not supported on this platform")
+}
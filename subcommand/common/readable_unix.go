@@ -0,0 +1,61 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// AssertReadableByUID returns an error describing why the user with uid
+// can't read the file at path, judging by the file's mode and owner, or nil
+// if it can. It is meant to be called after writing a token for a consumer
+// that runs as a different user. Group permissions only apply if the user's
+// groups can be looked up.
+func AssertReadableByUID(path string, uid int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %s", path, err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("unable to determine the owner of %s", path)
+	}
+	mode := info.Mode().Perm()
+	switch {
+	case uid == 0:
+		return nil
+	case int(stat.Uid) == uid:
+		if mode&0400 == 0 {
+			return fmt.Errorf("%s is owned by uid %d but its mode %s does not allow the owner to read it", path, uid, mode)
+		}
+		return nil
+	case mode&0004 != 0:
+		return nil
+	case mode&0040 != 0 && inGroup(uid, int(stat.Gid)):
+		return nil
+	}
+	return fmt.Errorf("%s is owned by uid %d and gid %d and its mode %s does not allow uid %d to read it", path, stat.Uid, stat.Gid, mode, uid)
+}
+
+// inGroup returns true if the user with uid is a member of the group with gid.
+func inGroup(uid, gid int) bool {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return false
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return u.Gid == strconv.Itoa(gid)
+	}
+	for _, g := range gids {
+		if g == strconv.Itoa(gid) {
+			return true
+		}
+	}
+	return false
+}
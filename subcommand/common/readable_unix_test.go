@@ -0,0 +1,52 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssertReadableByUID(t *testing.T) {
+	t.Parallel()
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of a file requires root")
+	}
+	const owner, other = 1000, 2000
+	cases := map[string]struct {
+		mode   os.FileMode
+		uid    int
+		expErr string
+	}{
+		"owner can read":     {mode: 0400, uid: owner},
+		"root can read":      {mode: 0000, uid: 0},
+		"others can read":    {mode: 0444, uid: other},
+		"owner cannot read":  {mode: 0044, uid: owner, expErr: "%s is owned by uid 1000 but its mode ----r--r-- does not allow the owner to read it"},
+		"non-owner no perms": {mode: 0440, uid: other, expErr: "%s is owned by uid 1000 and gid 1000 and its mode -r--r----- does not allow uid 2000 to read it"},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := WriteTempFile(t, "token")
+			require.NoError(t, os.Chown(path, owner, owner))
+			require.NoError(t, os.Chmod(path, c.mode))
+			err := AssertReadableByUID(path, c.uid)
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, fmt.Sprintf(c.expErr, path))
+			}
+		})
+	}
+}
+
+func TestAssertReadableByUID_Missing(t *testing.T) {
+	t.Parallel()
+	err := AssertReadableByUID("/does/not/exist", 1000)
+	require.EqualError(t, err, "unable to stat /does/not/exist: stat /does/not/exist: no such file or directory")
+}
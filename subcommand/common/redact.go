@@ -0,0 +1,71 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// redacted replaces secret values in the output of LoginConfig.Redacted.
+const redacted = "<redacted>"
+
+// Redacted returns a human readable dump of the effective settings in cfg
+// that is safe to attach to bug reports. File paths and names are shown as
+// is, but anything that could be a token, such as a Meta value that looks
+// like a JWT or whose key mentions a token, is masked.
+func (cfg LoginConfig) Redacted() string {
+	var b strings.Builder
+	line := func(name string, value interface{}) {
+		fmt.Fprintf(&b, "%s: %v\n", name, value)
+	}
+	line("HTTPAddr", cfg.HTTPAddr)
+	line("BearerTokenFile", cfg.BearerTokenFile)
+	line("BearerTokenFiles", strings.Join(cfg.BearerTokenFiles, ","))
+	line("BearerTokenBase64", cfg.BearerTokenBase64)
+	line("MaxTokenBytes", cfg.MaxTokenBytes)
+	line("AuthMethod", cfg.AuthMethod)
+	line("AuthMethodFile", cfg.AuthMethodFile)
+	line("RequiredAuthMethodType", cfg.RequiredAuthMethodType)
+	line("TokenSinkFile", cfg.TokenSinkFile)
+	line("SinkFilePrefix", cfg.SinkFilePrefix)
+	line("SinkFileSuffix", cfg.SinkFileSuffix)
+	line("TokenSinkDir", cfg.TokenSinkDir)
+	line("TokenSink", cfg.TokenSink != nil)
+	line("ForbidTokenOverwrite", cfg.ForbidTokenOverwrite)
+	line("SinkPathPrefix", cfg.SinkPathPrefix)
+	line("ForbidHostPathSink", cfg.ForbidHostPathSink)
+	// Only the name of the variable is shown, never the key it holds.
+	line("TokenEncryptionKeyEnv", cfg.TokenEncryptionKeyEnv)
+	line("WriteTokenDigest", cfg.WriteTokenDigest)
+	line("ConfirmSinkWrite", cfg.ConfirmSinkWrite)
+	line("ResponseSinkFile", cfg.ResponseSinkFile)
+	line("Namespace", cfg.Namespace)
+	line("RequiredServiceIdentity", cfg.RequiredServiceIdentity)
+	line("SkipDiagnostics", cfg.SkipDiagnostics)
+	line("FailoverClients", len(cfg.FailoverClients))
+	line("LockFile", cfg.LockFile)
+	line("PIDFile", cfg.PIDFile)
+	line("InitialDelay", cfg.InitialDelay)
+	line("MaxTotalDuration", cfg.MaxTotalDuration)
+	line("MaxLogBytes", cfg.MaxLogBytes)
+	line("ShouldRetry", cfg.ShouldRetry != nil)
+	line("AuditFile", cfg.AuditFile)
+	line("Preflight", cfg.Preflight)
+	line("Backoff", cfg.Backoff != nil)
+	line("Metrics", cfg.Metrics != nil)
+
+	keys := make([]string, 0, len(cfg.Meta))
+	for k := range cfg.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b.WriteString("Meta:\n")
+	for _, k := range keys {
+		v := cfg.Meta[k]
+		if looksLikeJWT(v) || strings.Contains(strings.ToLower(k), "token") {
+			v = redacted
+		}
+		fmt.Fprintf(&b, "  %s: %s\n", k, v)
+	}
+	return b.String()
+}
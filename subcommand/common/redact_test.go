@@ -0,0 +1,30 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginConfig_Redacted(t *testing.T) {
+	t.Parallel()
+	cfg := LoginConfig{
+		BearerTokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   "/consul/connect-inject/acl-token",
+		Meta: map[string]string{
+			"pod":             "default/pod",
+			"jwt":             testJWT,
+			"bootstrap-token": "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586",
+		},
+	}
+	out := cfg.Redacted()
+
+	require.Contains(t, out, "BearerTokenFile: /var/run/secrets/kubernetes.io/serviceaccount/token\n")
+	require.Contains(t, out, "TokenSinkFile: /consul/connect-inject/acl-token\n")
+	require.Contains(t, out, "  pod: default/pod\n")
+	require.Contains(t, out, "  jwt: <redacted>\n")
+	require.Contains(t, out, "  bootstrap-token: <redacted>\n")
+	require.NotContains(t, out, testJWT)
+	require.NotContains(t, out, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586")
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ReproCommand returns shell commands equivalent to the login ConsulLogin
+// makes with cfg, one using the consul CLI and one using curl, that users can
+// run to reproduce a failed login when asking for support. The bearer token
+// is never included: the consul command reads it from the same file and the
+// curl command has a placeholder for it.
+func ReproCommand(cfg LoginConfig) string {
+	addr := cfg.HTTPAddr
+	if addr == "" {
+		addr = "$CONSUL_HTTP_ADDR"
+	}
+	bearerTokenFile := cfg.BearerTokenFile
+	if bearerTokenFile == "" && len(cfg.BearerTokenFiles) > 0 {
+		bearerTokenFile = cfg.BearerTokenFiles[0]
+	}
+
+	keys := make([]string, 0, len(cfg.Meta))
+	for k := range cfg.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := []string{
+		"consul", "login",
+		"-http-addr=" + shellQuote(addr),
+		"-method=" + shellQuote(cfg.AuthMethod),
+		"-bearer-token-file=" + shellQuote(bearerTokenFile),
+		"-token-sink-file=" + shellQuote(cfg.SinkPath()),
+	}
+	if cfg.Namespace != "" {
+		args = append(args, "-namespace="+shellQuote(cfg.Namespace))
+	}
+	for _, k := range keys {
+		args = append(args, "-meta="+shellQuote(k+"="+cfg.Meta[k]))
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	// Keep the placeholder readable rather than escaping its brackets.
+	enc.SetEscapeHTML(false)
+	enc.Encode(struct {
+		AuthMethod  string
+		BearerToken string
+		Meta        map[string]string `json:",omitempty"`
+	}{
+		AuthMethod:  cfg.AuthMethod,
+		BearerToken: redacted,
+		Meta:        cfg.Meta,
+	})
+	url := strings.TrimSuffix(addr, "/") + "/v1/acl/login"
+	if cfg.Namespace != "" {
+		url += "?ns=" + cfg.Namespace
+	}
+	curl := fmt.Sprintf("curl -X POST %s --data %s", shellQuote(url), shellQuote(strings.TrimSpace(body.String())))
+
+	return strings.Join(args, " ") + "\n" + curl + "\n"
+}
+
+// shellQuote quotes s for a POSIX shell if it contains anything other than
+// characters that are always safe. Strings starting with an environment
+// variable reference are double quoted so the shell still expands it.
+func shellQuote(s string) string {
+	safe := s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,@", r))
+	}) == -1
+	switch {
+	case safe:
+		return s
+	case strings.HasPrefix(s, "$"):
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`").Replace(s) + `"`
+	default:
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReproCommand(t *testing.T) {
+	t.Parallel()
+	out := ReproCommand(LoginConfig{
+		HTTPAddr:        "https://consul-server.consul:8501",
+		BearerTokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   "/consul/connect-inject/acl-token",
+		Meta:            map[string]string{"pod": "default/my pod"},
+	})
+	require.Equal(t, "consul login -http-addr=https://consul-server.consul:8501 -method=consul-k8s-auth-method "+
+		"-bearer-token-file=/var/run/secrets/kubernetes.io/serviceaccount/token "+
+		"-token-sink-file=/consul/connect-inject/acl-token -meta='pod=default/my pod'\n"+
+		`curl -X POST https://consul-server.consul:8501/v1/acl/login --data '{"AuthMethod":"consul-k8s-auth-method","BearerToken":"<redacted>","Meta":{"pod":"default/my pod"}}'`+"\n", out)
+}
+
+func TestReproCommand_DefaultAddr(t *testing.T) {
+	t.Parallel()
+	out := ReproCommand(LoginConfig{
+		BearerTokenFile: WriteTempFile(t, testJWT),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   "/consul/connect-inject/acl-token",
+		Namespace:       "ns",
+	})
+	require.Contains(t, out, `-http-addr="$CONSUL_HTTP_ADDR" -method=consul-k8s-auth-method`)
+	require.Contains(t, out, "-namespace=ns")
+	require.Contains(t, out, `curl -X POST "$CONSUL_HTTP_ADDR/v1/acl/login?ns=ns"`)
+	require.NotContains(t, out, testJWT)
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// RequestIDHeader is the header each login request's ID is sent in.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key for the request ID.
+type requestIDKey struct{}
+
+// WithRequestID returns a new random request ID and a logger that includes it
+// in every line. ConsulLogin uses one per attempt so that logs can be
+// correlated with the request Consul received across retries.
+func WithRequestID(logger hclog.Logger) (hclog.Logger, string) {
+	b := make([]byte, 8)
+	// crypto/rand only fails if the OS has no randomness source, in which
+	// case an all zero ID is still better than none.
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	return logger.With("request-id", id), id
+}
+
+// contextWithRequestID returns a context that makes clients built by this
+// package send id in the RequestIDHeader header.
+func contextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDTransport is an http.RoundTripper that sets the RequestIDHeader
+// header of requests whose context has a request ID.
+type requestIDTransport struct {
+	next http.RoundTripper
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id, ok := req.Context().Value(requestIDKey{}).(string)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the request they are given.
+	req = req.Clone(req.Context())
+	req.Header.Set(RequestIDHeader, id)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConsulLogin_RequestID ensures that the request ID sent with a login
+// is the one that appears in its logs.
+func TestConsulLogin_RequestID(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	var headerID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/acl/login" {
+			headerID = r.Header.Get(RequestIDHeader)
+		}
+		w.Write([]byte(testLoginResponse))
+	}))
+	t.Cleanup(server.Close)
+	client, err := ConsulClientWithOptions(&api.Config{Address: server.URL}, ClientOptions{})
+	require.NoError(err)
+
+	var logs bytes.Buffer
+	cfg := testLoginConfig(t, client)
+	cfg.Logger = hclog.New(&hclog.LoggerOptions{Level: hclog.Debug, Output: &logs})
+	_, err = ConsulLogin(cfg)
+	require.NoError(err)
+	require.Len(headerID, 16)
+	require.Contains(logs.String(), "Logging in to Consul")
+	require.Contains(logs.String(), "request-id="+headerID)
+}
+
+func TestWithRequestID(t *testing.T) {
+	t.Parallel()
+	var logs bytes.Buffer
+	logger, id := WithRequestID(hclog.New(&hclog.LoggerOptions{Output: &logs}))
+	logger.Info("attempt")
+	require.Contains(t, logs.String(), "attempt: request-id="+id)
+
+	// Every attempt gets its own ID.
+	_, other := WithRequestID(logger)
+	require.NotEqual(t, id, other)
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimitError is returned by ConsulLogin when Consul rejected the login
+// with 429 Too Many Requests and said when to retry in a Retry-After header.
+// RunLoginLoop and LoginWithRetry wait RetryAfter before retrying instead of
+// using their normal backoff.
+type RateLimitError struct {
+	// RetryAfter is how long Consul asked to wait before retrying.
+	RetryAfter time.Duration
+	// Err is the login error.
+	Err error
+}
+
+func (e *RateLimitError) Error() string { return e.Err.Error() }
+
+func (e *RateLimitError) Unwrap() error { return e.Err }
+
+// retryAfter returns how long to wait before retrying after err, if err is a
+// RateLimitError.
+func retryAfter(err error) (time.Duration, bool) {
+	var rateLimited *RateLimitError
+	if errors.As(err, &rateLimited) {
+		return rateLimited.RetryAfter, true
+	}
+	return 0, false
+}
+
+// retryAfterKey is the context key for the retryAfterTarget of a request.
+type retryAfterKey struct{}
+
+// retryAfterTarget is where retryAfterTransport stores the Retry-After of a
+// rate limited response and the clock HTTP dates in it are relative to.
+type retryAfterTarget struct {
+	d     *time.Duration
+	clock Clock
+}
+
+// contextWithRetryAfter returns a context that makes clients built by this
+// package store the Retry-After of a 429 response in d, using clock to turn
+// HTTP dates into durations.
+func contextWithRetryAfter(ctx context.Context, d *time.Duration, clock Clock) context.Context {
+	return context.WithValue(ctx, retryAfterKey{}, retryAfterTarget{d: d, clock: clock})
+}
+
+// retryAfterTransport is an http.RoundTripper that stores the Retry-After of
+// 429 responses to requests whose context asks for it.
+type retryAfterTransport struct {
+	next http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		return resp, err
+	}
+	if target, ok := req.Context().Value(retryAfterKey{}).(retryAfterTarget); ok {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), target.clock.Now()); ok {
+			*target.d = wait
+		}
+	}
+	return resp, nil
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date, into how long to wait from now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := at.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
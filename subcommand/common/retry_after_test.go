@@ -0,0 +1,114 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoginWithRetry_RetryAfter ensures that a rate limited login is retried
+// after the Retry-After delay rather than the backoff.
+func TestLoginWithRetry_RetryAfter(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/acl/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.AddInt32(&logins, 1) == 1 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("rate limit exceeded"))
+			return
+		}
+		w.Write([]byte(testLoginResponse))
+	}))
+	t.Cleanup(server.Close)
+	client, err := ConsulClientWithOptions(&api.Config{Address: server.URL}, ClientOptions{})
+	require.NoError(t, err)
+
+	clock := &recordingClock{FakeClock: NewFakeClock(time.Now()), done: func() {}}
+	cfg := testLoginConfig(t, client)
+	cfg.Backoff = NewBackoff(backoff.NewConstantBackOff(time.Second), 1)
+	cfg.Clock = clock
+	resp, err := LoginWithRetry(context.Background(), cfg, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", resp.SecretID)
+	require.Equal(t, int32(2), atomic.LoadInt32(&logins))
+	require.Equal(t, []time.Duration{7 * time.Second}, clock.waits)
+}
+
+func TestConsulLogin_RateLimitError(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("rate limit exceeded"))
+	}))
+	t.Cleanup(server.Close)
+	client, err := ConsulClientWithOptions(&api.Config{Address: server.URL}, ClientOptions{})
+	require.NoError(t, err)
+
+	_, err = ConsulLogin(testLoginConfig(t, client))
+	require.EqualError(t, err, "error logging in: Unexpected response code: 429 (rate limit exceeded)")
+	d, ok := retryAfter(err)
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, d)
+	require.Equal(t, ErrorClassRetryable, ClassifyError(err))
+}
+
+// TestConsulLogin_RateLimitErrorDate ensures that a Retry-After HTTP date is
+// relative to cfg.Clock.
+func TestConsulLogin_RateLimitErrorDate(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "Tue, 01 Jun 2021 12:00:30 GMT")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("rate limit exceeded"))
+	}))
+	t.Cleanup(server.Close)
+	client, err := ConsulClientWithOptions(&api.Config{Address: server.URL}, ClientOptions{})
+	require.NoError(t, err)
+
+	cfg := testLoginConfig(t, client)
+	cfg.Clock = NewFakeClock(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+	_, err = ConsulLogin(cfg)
+	d, ok := retryAfter(err)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, d)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		value string
+		exp   time.Duration
+		expOK bool
+	}{
+		"seconds":     {value: "120", exp: 2 * time.Minute, expOK: true},
+		"zero":        {value: "0", exp: 0, expOK: true},
+		"http date":   {value: "Tue, 01 Jun 2021 12:00:30 GMT", exp: 30 * time.Second, expOK: true},
+		"past date":   {value: "Tue, 01 Jun 2021 11:00:00 GMT", exp: 0, expOK: true},
+		"empty":       {value: ""},
+		"negative":    {value: "-1"},
+		"not a value": {value: "soon"},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			d, ok := parseRetryAfter(c.value, now)
+			require.Equal(t, c.expOK, ok)
+			require.Equal(t, c.exp, d)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// RotatingFileWriter returns a writer that appends to the file at path and
+// rotates it once it would grow past maxBytes. Rotated files are renamed to
+// path.1, path.2 and so on, with path.1 being the most recent, and at most
+// keep of them are kept. It is meant to be used as the output of a logger
+// when logging to a file. A single write larger than maxBytes is written to
+// a fresh file rather than split.
+func RotatingFileWriter(path string, maxBytes int, keep int) (io.WriteCloser, error) {
+	if maxBytes <= 0 {
+		return nil, errors.New("maxBytes must be positive")
+	}
+	if keep < 0 {
+		return nil, errors.New("keep must not be negative")
+	}
+	w := &rotatingFileWriter{path: path, maxBytes: int64(maxBytes), keep: keep}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+type rotatingFileWriter struct {
+	path     string
+	maxBytes int64
+	keep     int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (w *rotatingFileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// open opens path for appending and records its current size.
+func (w *rotatingFileWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open log file: %s", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("unable to stat log file: %s", err)
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest file, moves path to
+// path.1 and opens a new file at path.
+func (w *rotatingFileWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("unable to close log file: %s", err)
+	}
+	if w.keep == 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove log file: %s", err)
+		}
+		return w.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", w.path, w.keep))
+	for i := w.keep - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to rotate log file: %s", err)
+		}
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return fmt.Errorf("unable to rotate log file: %s", err)
+	}
+	return w.open()
+}
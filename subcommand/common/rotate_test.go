@@ -0,0 +1,59 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFileWriter(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "login.log")
+
+	w, err := RotatingFileWriter(path, 10, 2)
+	require.NoError(err)
+	// Each write fills the file so every following write rotates it.
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := w.Write([]byte(line))
+		require.NoError(err)
+	}
+	require.NoError(w.Close())
+
+	for file, exp := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(err)
+		require.Equal(exp, string(data))
+	}
+	// No more than keep rotated files are kept.
+	_, err = os.Stat(path + ".3")
+	require.True(os.IsNotExist(err))
+}
+
+func TestRotatingFileWriter_AppendsUntilLimit(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "login.log")
+
+	w, err := RotatingFileWriter(path, 10, 1)
+	require.NoError(err)
+	for i := 0; i < 5; i++ {
+		_, err := w.Write([]byte("ab"))
+		require.NoError(err)
+	}
+	require.NoError(w.Close())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	require.Equal(strings.Repeat("ab", 5), string(data))
+	_, err = os.Stat(path + ".1")
+	require.True(os.IsNotExist(err))
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// ErrorSink is where commands report errors meant for humans, separately from
+// their logs, so that for example errors go to stderr while logs go to a
+// file. cli.Ui implements it.
+type ErrorSink interface {
+	Error(msg string)
+}
+
+// WriterErrorSink is an ErrorSink that writes each error on its own line to W.
+type WriterErrorSink struct {
+	W io.Writer
+}
+
+// Error implements ErrorSink.
+func (s WriterErrorSink) Error(msg string) {
+	fmt.Fprintln(s.W, msg)
+}
+
+// Run runs fn, the body of a command, with logger and returns the command's
+// exit code: 0 if fn succeeds and 1 otherwise. The error fn returns is
+// reported to errs rather than logged so it isn't lost in the logs.
+func Run(logger hclog.Logger, errs ErrorSink, fn func(logger hclog.Logger) error) int {
+	if err := fn(logger); err != nil {
+		errs.Error(err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	var logs, errs bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &logs})
+
+	code := Run(logger, WriterErrorSink{W: &errs}, func(logger hclog.Logger) error {
+		logger.Info("Logging in to Consul")
+		return errors.New("unable to read bearer token")
+	})
+	require.Equal(t, 1, code)
+	require.Contains(t, logs.String(), "[INFO]  Logging in to Consul")
+	require.NotContains(t, logs.String(), "unable to read bearer token")
+	require.Equal(t, "unable to read bearer token\n", errs.String())
+}
+
+func TestRun_Success(t *testing.T) {
+	t.Parallel()
+	var errs bytes.Buffer
+	code := Run(hclog.NewNullLogger(), WriterErrorSink{W: &errs}, func(hclog.Logger) error {
+		return nil
+	})
+	require.Equal(t, 0, code)
+	require.Empty(t, errs.String())
+}
+
+// TestRun_UI ensures that a cli.Ui can be used as the ErrorSink.
+func TestRun_UI(t *testing.T) {
+	t.Parallel()
+	ui := cli.NewMockUi()
+	code := Run(hclog.NewNullLogger(), ui, func(hclog.Logger) error {
+		return errors.New("boom")
+	})
+	require.Equal(t, 1, code)
+	require.Equal(t, "boom\n", ui.ErrorWriter.String())
+}
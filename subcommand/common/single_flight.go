@@ -0,0 +1,122 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// loginGroup collapses concurrent logins made by SingleFlightLogin.
+var loginGroup singleflight.Group
+
+// SingleFlightLogin is like ConsulLogin but concurrent calls with the same
+// config, from any goroutine, share a single login instead of each creating
+// a token. Configs are the same if every setting that changes what
+// ConsulLogin does is equal, with clients, loggers, metrics and clocks
+// compared by identity. Logins to a TokenSink are never shared since sinks
+// can't be compared. Callers share the returned LoginResponse so they must
+// not modify it.
+func SingleFlightLogin(cfg LoginConfig) (*LoginResponse, error) {
+	if cfg.TokenSink != nil || cfg.fsType != nil || cfg.mountInfo != nil {
+		return ConsulLogin(cfg)
+	}
+	resp, err, _ := loginGroup.Do(loginKey(cfg), func() (interface{}, error) {
+		return ConsulLogin(cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*LoginResponse), nil
+}
+
+// loginKey returns the key identifying cfg's login in loginGroup. Settings
+// only used by RunLoginLoop and LoginWithRetry, such as Backoff, and
+// HTTPAddr, which only describes the login, are left out.
+func loginKey(cfg LoginConfig) string {
+	failoverClients := make([]string, len(cfg.FailoverClients))
+	for i, client := range cfg.FailoverClients {
+		failoverClients[i] = identity(client)
+	}
+	// Maps are encoded with their keys sorted, so equal configs always
+	// encode the same.
+	key, _ := json.Marshal(struct {
+		Client                  string
+		FailoverClients         []string
+		Preflight               bool
+		BearerTokenFile         string
+		BearerTokenFiles        []string
+		BearerTokenBase64       bool
+		MaxTokenBytes           int64
+		AuthMethod              string
+		AuthMethodFile          string
+		RequiredAuthMethodType  string
+		TokenSinkFile           string
+		SinkFilePrefix          string
+		SinkFileSuffix          string
+		TokenSinkDir            string
+		ForbidTokenOverwrite    bool
+		SinkPathPrefix          string
+		ForbidHostPathSink      bool
+		TokenEncryptionKeyEnv   string
+		WriteTokenDigest        bool
+		ConfirmSinkWrite        bool
+		ResponseSinkFile        string
+		Namespace               string
+		Meta                    map[string]string
+		RequiredServiceIdentity string
+		SkipDiagnostics         bool
+		Metrics                 string
+		AuditFile               string
+		Logger                  string
+		MaxLogBytes             int
+		InitialDelay            time.Duration
+		Clock                   string
+		DefaultBearerTokenFile  string
+	}{
+		Client:                  identity(cfg.Client),
+		FailoverClients:         failoverClients,
+		Preflight:               cfg.Preflight,
+		BearerTokenFile:         cfg.BearerTokenFile,
+		BearerTokenFiles:        cfg.BearerTokenFiles,
+		BearerTokenBase64:       cfg.BearerTokenBase64,
+		MaxTokenBytes:           cfg.MaxTokenBytes,
+		AuthMethod:              cfg.AuthMethod,
+		AuthMethodFile:          cfg.AuthMethodFile,
+		RequiredAuthMethodType:  cfg.RequiredAuthMethodType,
+		TokenSinkFile:           cfg.TokenSinkFile,
+		SinkFilePrefix:          cfg.SinkFilePrefix,
+		SinkFileSuffix:          cfg.SinkFileSuffix,
+		TokenSinkDir:            cfg.TokenSinkDir,
+		ForbidTokenOverwrite:    cfg.ForbidTokenOverwrite,
+		SinkPathPrefix:          cfg.SinkPathPrefix,
+		ForbidHostPathSink:      cfg.ForbidHostPathSink,
+		TokenEncryptionKeyEnv:   cfg.TokenEncryptionKeyEnv,
+		WriteTokenDigest:        cfg.WriteTokenDigest,
+		ConfirmSinkWrite:        cfg.ConfirmSinkWrite,
+		ResponseSinkFile:        cfg.ResponseSinkFile,
+		Namespace:               cfg.Namespace,
+		Meta:                    cfg.Meta,
+		RequiredServiceIdentity: cfg.RequiredServiceIdentity,
+		SkipDiagnostics:         cfg.SkipDiagnostics,
+		Metrics:                 identity(cfg.Metrics),
+		AuditFile:               cfg.AuditFile,
+		Logger:                  identity(cfg.Logger),
+		MaxLogBytes:             cfg.MaxLogBytes,
+		InitialDelay:            cfg.InitialDelay,
+		Clock:                   identity(cfg.Clock),
+		DefaultBearerTokenFile:  cfg.defaultBearerTokenFile,
+	})
+	return string(key)
+}
+
+// identity returns a string identifying v, its address if it is a pointer
+// and its value otherwise, such as for RealClock.
+func identity(v interface{}) string {
+	if v == nil || reflect.ValueOf(v).Kind() != reflect.Ptr {
+		return fmt.Sprintf("%T %v", v, v)
+	}
+	return fmt.Sprintf("%T %p", v, v)
+}
@@ -0,0 +1,192 @@
+package common
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleFlightLogin(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	release := make(chan struct{})
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/acl/login" {
+			atomic.AddInt32(&logins, 1)
+			<-release
+		}
+		w.Write([]byte(testLoginResponse))
+	})
+
+	cfg := testLoginConfig(t, client)
+	const callers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := SingleFlightLogin(cfg)
+			if err == nil && resp.SecretID != "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586" {
+				t.Errorf("unexpected SecretID %q", resp.SecretID)
+			}
+			errs <- err
+		}()
+	}
+	// Hold the login until every caller has had time to join it.
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&logins) == 1 }, 5*time.Second, time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&logins))
+}
+
+func TestLoginKey(t *testing.T) {
+	t.Parallel()
+	cfg := LoginConfig{AuthMethod: "a", Namespace: "ns", BearerTokenFile: "/token", TokenSinkFile: "/sink"}
+	require.Equal(t, loginKey(cfg), loginKey(cfg))
+
+	other := cfg
+	other.Namespace = "other"
+	require.NotEqual(t, loginKey(cfg), loginKey(other))
+
+	withMeta := cfg
+	withMeta.Meta = map[string]string{"pod": "default/a", "node": "n1"}
+	sameMeta := cfg
+	sameMeta.Meta = map[string]string{"node": "n1", "pod": "default/a"}
+	otherMeta := cfg
+	otherMeta.Meta = map[string]string{"pod": "default/b", "node": "n1"}
+	require.Equal(t, loginKey(withMeta), loginKey(sameMeta))
+	require.NotEqual(t, loginKey(withMeta), loginKey(otherMeta))
+
+	client, err := api.NewClient(api.DefaultConfig())
+	require.NoError(t, err)
+	otherClient, err := api.NewClient(api.DefaultConfig())
+	require.NoError(t, err)
+	withClient := cfg
+	withClient.Client = client
+	withOtherClient := cfg
+	withOtherClient.Client = otherClient
+	require.NotEqual(t, loginKey(withClient), loginKey(withOtherClient))
+}
+
+// TestLoginKey_CoversLoginConfig ensures that changing any LoginConfig
+// field that ConsulLogin uses changes the key, so that logins are only
+// shared by callers that would have made the same one.
+func TestLoginKey_CoversLoginConfig(t *testing.T) {
+	t.Parallel()
+	// notInKey are the fields ConsulLogin doesn't use, or that make
+	// SingleFlightLogin not share the login.
+	notInKey := map[string]bool{
+		"HTTPAddr":         true,
+		"TokenSink":        true,
+		"Backoff":          true,
+		"LockFile":         true,
+		"PIDFile":          true,
+		"ErrorHistory":     true,
+		"MaxTotalDuration": true,
+		"ShouldRetry":      true,
+		"fsType":           true,
+		"mountInfo":        true,
+	}
+	client, err := api.NewClient(api.DefaultConfig())
+	require.NoError(t, err)
+	// values are the values of the fields whose type has no obvious
+	// non-zero value.
+	values := map[string]interface{}{
+		"Client":          client,
+		"FailoverClients": []*api.Client{client},
+		"Metrics":         &LoginMetrics{},
+		"Logger":          hclog.NewNullLogger(),
+		"Clock":           NewFakeClock(time.Now()),
+	}
+
+	base := loginKey(LoginConfig{})
+	typ := reflect.TypeOf(LoginConfig{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if notInKey[field.Name] {
+			continue
+		}
+		var cfg LoginConfig
+		v := reflect.ValueOf(&cfg).Elem().Field(i)
+		if field.Name == "defaultBearerTokenFile" {
+			cfg.defaultBearerTokenFile = "x"
+		} else if !v.CanSet() {
+			t.Fatalf("no value for LoginConfig.%s; set it above or add it to notInKey", field.Name)
+		} else if value, ok := values[field.Name]; ok {
+			v.Set(reflect.ValueOf(value))
+		} else {
+			switch v.Kind() {
+			case reflect.String:
+				v.SetString("x")
+			case reflect.Bool:
+				v.SetBool(true)
+			case reflect.Int, reflect.Int64:
+				v.SetInt(1)
+			case reflect.Slice:
+				v.Set(reflect.ValueOf([]string{"x"}))
+			case reflect.Map:
+				v.Set(reflect.ValueOf(map[string]string{"x": "x"}))
+			default:
+				t.Fatalf("no value for LoginConfig.%s; add it to values or notInKey", field.Name)
+			}
+		}
+		require.NotEqual(t, base, loginKey(cfg), "LoginConfig.%s isn't part of the login key", field.Name)
+	}
+}
+
+// TestSingleFlightLogin_TokenSinks ensures that concurrent logins to
+// different TokenSinks are not merged, so that every sink is written.
+func TestSingleFlightLogin_TokenSinks(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	release := make(chan struct{})
+	client := startMockConsul(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/acl/login" {
+			atomic.AddInt32(&logins, 1)
+			<-release
+		}
+		w.Write([]byte(testLoginResponse))
+	})
+
+	vaults := []*mockVault{startMockVault(t), startMockVault(t)}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(vaults))
+	for _, vault := range vaults {
+		cfg := LoginConfig{
+			Client:          client,
+			BearerTokenFile: WriteTempFile(t, "foo"),
+			AuthMethod:      testAuthMethod,
+			TokenSink:       VaultSink{Address: vault.URL, Token: "vault-token", Path: "secret/consul-token"},
+			Meta:            testPodMeta,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := SingleFlightLogin(cfg)
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&logins) == 2 }, 5*time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	for _, vault := range vaults {
+		require.Len(t, vault.stored, 1)
+	}
+}
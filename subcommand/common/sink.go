@@ -0,0 +1,265 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// currentTokenLink is the name of the symlink WriteTokenToDir points at
+	// the most recently written token.
+	currentTokenLink = "current"
+	// tokenFileTimeFormat is used to name the files written by WriteTokenToDir
+	// so that they sort in the order they were written.
+	tokenFileTimeFormat = "20060102T150405.000000000Z"
+)
+
+// WriteTokenToDir writes token to a new file in dir named after the current
+// time and then points the "current" symlink in dir at it, so that every
+// login is kept for auditing while consumers can always read dir/current.
+// The symlink is replaced atomically.
+func WriteTokenToDir(dir, token string) error {
+	return writeTokenToDir(dir, token, time.Now())
+}
+
+// writeTokenToDir is WriteTokenToDir with the current time passed in.
+func writeTokenToDir(dir, token string, now time.Time) error {
+	name := "token-" + now.UTC().Format(tokenFileTimeFormat)
+	if err := WriteFileWithPerms(filepath.Join(dir, name), token, 0444); err != nil {
+		return err
+	}
+	tmpLink := filepath.Join(dir, "."+currentTokenLink+".tmp")
+	os.Remove(tmpLink)
+	// Use a relative target so the link still works if dir is mounted
+	// somewhere else.
+	if err := os.Symlink(name, tmpLink); err != nil {
+		return fmt.Errorf("unable to create symlink: %s", err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(dir, currentTokenLink)); err != nil {
+		os.Remove(tmpLink)
+		return fmt.Errorf("unable to update %s symlink: %s", currentTokenLink, err)
+	}
+	return nil
+}
+
+// UniqueSinkPath returns base with a short hash of the pod identity in meta
+// appended, for example "/consul/acl-token-1a2b3c4d". It avoids collisions
+// when many pods write their tokens to the same host volume. The result only
+// depends on the contents of meta so it is stable across restarts of the
+// same pod.
+func UniqueSinkPath(base string, meta map[string]string) string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		// Separate keys and values with NUL so that different maps can't
+		// produce the same input.
+		fmt.Fprintf(h, "%s\x00%s\x00", k, meta[k])
+	}
+	return fmt.Sprintf("%s-%s", base, hex.EncodeToString(h.Sum(nil))[:8])
+}
+
+// DecorateSinkName returns path with prefix and suffix added around its file
+// name, keeping its directory. For example, "/consul/acl-token" with the
+// prefix "node-1-" and the suffix ".tok" is "/consul/node-1-acl-token.tok".
+func DecorateSinkName(path, prefix, suffix string) string {
+	dir, name := filepath.Split(path)
+	return dir + prefix + name + suffix
+}
+
+// AssertSinksConsistent returns an error unless all of the files in paths
+// contain the same token. It is a consistency check to run after writing a
+// token to several sinks.
+func AssertSinksConsistent(paths []string) error {
+	var first string
+	for i, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read token sink: %s", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if i == 0 {
+			first = token
+			continue
+		}
+		if token != first {
+			return fmt.Errorf("token sinks %s and %s contain different tokens", paths[0], path)
+		}
+	}
+	return nil
+}
+
+// WriteSinksTransactional writes token to each of the sinks in paths in
+// order. If a write fails, the sinks already written are removed again so
+// that consumers never see a token in only some of them. Named pipes are not
+// removed since the token they were given can't be taken back.
+func WriteSinksTransactional(paths []string, token string) error {
+	for i, path := range paths {
+		if err := writeTokenSink(path, token); err != nil {
+			for _, written := range paths[:i] {
+				if !isFIFO(written) {
+					os.Remove(written)
+				}
+			}
+			return fmt.Errorf("unable to write token sink %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// AssertSinkUnderPrefix returns an error unless path is allowedPrefix or is
+// inside it. It is used to make sure tokens are only written to expected
+// volumes and not, for example, into the container image's filesystem.
+func AssertSinkUnderPrefix(path, allowedPrefix string) error {
+	rel, err := filepath.Rel(filepath.Clean(allowedPrefix), filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("token sink %s is not under allowed prefix %s", path, allowedPrefix)
+	}
+	return nil
+}
+
+// writeTokenSink writes token to the sink at path. If path is a named pipe the
+// token is written to it directly, since replacing the pipe with a regular
+// file would put the token on disk. Otherwise it is atomically written to a
+// read-only regular file.
+func writeTokenSink(path, token string) error {
+	if isFIFO(path) {
+		return writeFIFO(path, token)
+	}
+	return writeFileAtomic(path, token, 0444)
+}
+
+// writeResponseSink writes resp as JSON to path for consumers that want the
+// accessor ID and expiration along with the token. The SecretID is replaced
+// by secret so that an encrypted token stays encrypted.
+func writeResponseSink(path string, resp *LoginResponse, secret string) error {
+	r := *resp
+	r.SecretID = secret
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, string(data), 0444)
+}
+
+// writeFileAtomic is like WriteFileWithPerms but writes to a temporary file
+// in the same directory and renames it over path, so that concurrent readers
+// see either the old or the new contents in full and never a partial file.
+func writeFileAtomic(path, payload string, mode os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".consul-login-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %s", err)
+	}
+	tmp := f.Name()
+	f.Close()
+	if err := WriteFileWithPerms(tmp, payload, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to replace file: %s", err)
+	}
+	return nil
+}
+
+const (
+	// confirmWriteAttempts is how many times ConfirmWrite stats the file.
+	confirmWriteAttempts = 10
+	// confirmWriteInterval is how long ConfirmWrite waits between stats.
+	confirmWriteInterval = 10 * time.Millisecond
+)
+
+// ConfirmWrite waits until a write of payload to path that started at since
+// is observable: the file's modification time is not before since and its
+// size and contents match payload. Consumers watching the file with inotify
+// are then guaranteed to see the new token when they are notified. Since
+// some filesystems only store modification times to the second, since is
+// truncated to the second.
+func ConfirmWrite(path, payload string, since time.Time) error {
+	return confirmWrite(path, payload, since, RealClock{})
+}
+
+// confirmWrite is ConfirmWrite waiting between attempts on clock.
+func confirmWrite(path, payload string, since time.Time, clock Clock) error {
+	since = since.Truncate(time.Second)
+	var reason string
+	for i := 0; i < confirmWriteAttempts; i++ {
+		if i > 0 {
+			<-clock.After(confirmWriteInterval)
+		}
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case info.ModTime().Before(since):
+			reason = fmt.Sprintf("modification time %s is before the write", info.ModTime().Format(time.RFC3339Nano))
+		case info.Size() != int64(len(payload)):
+			reason = fmt.Sprintf("size is %d bytes instead of %d", info.Size(), len(payload))
+		default:
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				reason = err.Error()
+			} else if string(data) != payload {
+				reason = "contents don't match what was written"
+			} else {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("write to %s was not observed: %s", path, reason)
+}
+
+// checkSinkWritable makes sure a token can be written to path by creating and
+// removing a temporary file in its directory. It's used before logging in so
+// that we don't create an ACL token we then can't write out.
+func checkSinkWritable(path string) error {
+	if isFIFO(path) {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".consul-login-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// isFIFO returns true if path exists and is a named pipe.
+func isFIFO(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// writeFIFO writes payload to the named pipe at path. Opening the pipe blocks
+// until there is a reader on the other end. If the reader goes away before
+// the whole payload is written, an error is returned. The Go runtime only
+// lets SIGPIPE kill the process for writes to stdout and stderr, so this
+// can't crash it.
+func writeFIFO(path, payload string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open named pipe: %s", err)
+	}
+	if _, err := f.WriteString(payload); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EPIPE) {
+			return fmt.Errorf("reader of named pipe %s went away before the token was written", path)
+		}
+		return fmt.Errorf("unable to write to named pipe: %s", err)
+	}
+	return f.Close()
+}
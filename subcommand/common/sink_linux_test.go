@@ -0,0 +1,80 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestConsulLogin_FIFOSink ensures that when the token sink is a named pipe
+// the token is delivered through the pipe and the pipe is left in place.
+func TestConsulLogin_FIFOSink(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	fifo := filepath.Join(t.TempDir(), "acl-token")
+	require.NoError(syscall.Mkfifo(fifo, 0600))
+
+	// Read from the pipe concurrently since writes block until there's a reader.
+	received := make(chan string, 1)
+	go func() {
+		data, err := ioutil.ReadFile(fifo)
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		received <- string(data)
+	}()
+
+	counter := 0
+	_, err := ConsulLogin(LoginConfig{
+		Client:          startMockServer(t, &counter),
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   fifo,
+		Meta:            testPodMeta,
+	})
+	require.NoError(err)
+	require.Equal("b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", <-received)
+	require.True(isFIFO(fifo))
+}
+
+// TestWriteFIFO_ReaderClosed ensures that a write to a named pipe whose reader
+// goes away returns an error instead of killing the process with SIGPIPE.
+func TestWriteFIFO_ReaderClosed(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	fifo := filepath.Join(t.TempDir(), "acl-token")
+	require.NoError(syscall.Mkfifo(fifo, 0600))
+	// Opening the read end without blocking lets writeFIFO open the pipe.
+	reader, err := os.OpenFile(fifo, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	require.NoError(err)
+
+	// The payload is larger than the pipe's buffer so the write is still in
+	// progress when the reader closes the pipe.
+	done := make(chan error, 1)
+	go func() {
+		done <- writeFIFO(fifo, strings.Repeat("a", 1<<20))
+	}()
+	buf := make([]byte, 1)
+	require.Eventually(func() bool {
+		n, _ := reader.Read(buf)
+		return n == 1
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(reader.Close())
+
+	select {
+	case err := <-done:
+		require.EqualError(err, fmt.Sprintf("reader of named pipe %s went away before the token was written", fifo))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the write to fail")
+	}
+}
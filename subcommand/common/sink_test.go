@@ -0,0 +1,341 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssertSinkUnderPrefix(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		path   string
+		prefix string
+		expErr string
+	}{
+		"directly under prefix": {
+			path:   "/consul/connect-inject/acl-token",
+			prefix: "/consul/connect-inject",
+		},
+		"nested under prefix with trailing slash": {
+			path:   "/consul/connect-inject/tokens/acl-token",
+			prefix: "/consul/connect-inject/",
+		},
+		"outside prefix": {
+			path:   "/etc/acl-token",
+			prefix: "/consul/connect-inject",
+			expErr: "token sink /etc/acl-token is not under allowed prefix /consul/connect-inject",
+		},
+		"escapes prefix": {
+			path:   "/consul/connect-inject/../acl-token",
+			prefix: "/consul/connect-inject",
+			expErr: "token sink /consul/connect-inject/../acl-token is not under allowed prefix /consul/connect-inject",
+		},
+		"sibling with shared name prefix": {
+			path:   "/consul/connect-inject-other/acl-token",
+			prefix: "/consul/connect-inject",
+			expErr: "token sink /consul/connect-inject-other/acl-token is not under allowed prefix /consul/connect-inject",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			err := AssertSinkUnderPrefix(c.path, c.prefix)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestUniqueSinkPath(t *testing.T) {
+	t.Parallel()
+	podA := map[string]string{"pod": "default/a"}
+	podB := map[string]string{"pod": "default/b"}
+
+	pathA := UniqueSinkPath("/consul/acl-token", podA)
+	require.Regexp(t, `^/consul/acl-token-[0-9a-f]{8}$`, pathA)
+	require.NotEqual(t, pathA, UniqueSinkPath("/consul/acl-token", podB))
+	// The same pod always gets the same path.
+	require.Equal(t, pathA, UniqueSinkPath("/consul/acl-token", map[string]string{"pod": "default/a"}))
+}
+
+func TestDecorateSinkName(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		path, prefix, suffix string
+		exp                  string
+	}{
+		"prefix and suffix": {path: "/consul/acl-token", prefix: "node-1-", suffix: ".tok", exp: "/consul/node-1-acl-token.tok"},
+		"prefix only":       {path: "/consul/acl-token", prefix: "node-1-", exp: "/consul/node-1-acl-token"},
+		"suffix only":       {path: "/consul/acl-token", suffix: "-node-1", exp: "/consul/acl-token-node-1"},
+		"neither":           {path: "/consul/acl-token", exp: "/consul/acl-token"},
+		"relative path":     {path: "acl-token", prefix: "a-", suffix: "-b", exp: "a-acl-token-b"},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, c.exp, DecorateSinkName(c.path, c.prefix, c.suffix))
+		})
+	}
+}
+
+func TestConsulLogin_DecoratedSinkName(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	dir := t.TempDir()
+	_, err := ConsulLogin(LoginConfig{
+		Client:          startMockServer(t, &counter),
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   filepath.Join(dir, "acl-token"),
+		SinkFilePrefix:  "node-1-",
+		SinkFileSuffix:  ".tok",
+		Meta:            testPodMeta,
+	})
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "node-1-acl-token.tok"))
+	require.NoError(t, err)
+	require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", string(data))
+	_, err = os.Stat(filepath.Join(dir, "acl-token"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestAssertSinksConsistent(t *testing.T) {
+	t.Parallel()
+	a := WriteTempFile(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586")
+	b := WriteTempFile(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586")
+	require.NoError(t, AssertSinksConsistent([]string{a, b}))
+
+	c := WriteTempFile(t, "926e2bd2-b344-d91b-0c83-ae89f372cd9b")
+	err := AssertSinksConsistent([]string{a, b, c})
+	require.EqualError(t, err, fmt.Sprintf("token sinks %s and %s contain different tokens", a, c))
+}
+
+func TestWriteSinksTransactional(t *testing.T) {
+	t.Parallel()
+	const token = "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586"
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first")
+	second := filepath.Join(dir, "second")
+	require.NoError(t, WriteSinksTransactional([]string{first, second}, token))
+	require.NoError(t, AssertSinksConsistent([]string{first, second}))
+
+	// The second write fails because its directory doesn't exist, so the
+	// first sink must be rolled back.
+	rolledBack := filepath.Join(dir, "rolled-back")
+	missing := filepath.Join(dir, "missing", "second")
+	err := WriteSinksTransactional([]string{rolledBack, missing}, token)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unable to write token sink "+missing)
+	_, err = os.Stat(rolledBack)
+	require.True(t, os.IsNotExist(err))
+}
+
+// TestWriteTokenSink_ConcurrentReader ensures that a reader never sees a
+// partially written token while the sink is being rewritten.
+func TestWriteTokenSink_ConcurrentReader(t *testing.T) {
+	t.Parallel()
+	const token = "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586"
+	path := WriteTempFile(t, "")
+
+	stop := make(chan struct{})
+	started := make(chan struct{})
+	seen := make(chan string, 1)
+	go func() {
+		defer close(seen)
+		close(started)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil || (string(data) != "" && string(data) != token) {
+				seen <- fmt.Sprintf("%q (err: %v)", data, err)
+				return
+			}
+		}
+	}()
+
+	<-started
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, writeTokenSink(path, token))
+	}
+	close(stop)
+	for partial := range seen {
+		t.Fatalf("reader saw %s", partial)
+	}
+}
+
+func TestConsulLogin_SinkPathPrefix(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	_, err := ConsulLogin(LoginConfig{
+		Client:          startMockServer(t, &counter),
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   "/etc/acl-token",
+		SinkPathPrefix:  "/consul/connect-inject",
+		Meta:            testPodMeta,
+	})
+	require.EqualError(t, err, "token sink /etc/acl-token is not under allowed prefix /consul/connect-inject")
+	// We should fail before logging in.
+	require.Equal(t, 0, counter)
+}
+
+func TestConsulLogin_SinkDirUnwritable(t *testing.T) {
+	t.Parallel()
+	if os.Geteuid() == 0 {
+		t.Skip("directory permissions are not enforced for root")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.Chmod(dir, 0555))
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+
+	counter := 0
+	_, err := ConsulLogin(LoginConfig{
+		Client:          startMockServer(t, &counter),
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		TokenSinkFile:   filepath.Join(dir, "acl-token"),
+		Meta:            testPodMeta,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error writing token to file sink")
+	require.Equal(t, 0, counter)
+}
+
+func TestConsulLogin_TokenSinkDir(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	dir := t.TempDir()
+
+	counter := 0
+	for i := 0; i < 2; i++ {
+		_, err := ConsulLogin(LoginConfig{
+			Client:          startMockServer(t, &counter),
+			BearerTokenFile: WriteTempFile(t, "foo"),
+			AuthMethod:      testAuthMethod,
+			TokenSinkDir:    dir,
+			Meta:            testPodMeta,
+		})
+		require.NoError(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "token-*"))
+	require.NoError(err)
+	require.Len(files, 2)
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		require.NoError(err)
+		require.Equal("b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", string(data))
+	}
+
+	// current should point at the most recent token file.
+	target, err := os.Readlink(filepath.Join(dir, "current"))
+	require.NoError(err)
+	require.Equal(filepath.Base(files[1]), target)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "current"))
+	require.NoError(err)
+	require.Equal("b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", string(data))
+}
+
+func TestConfirmWrite(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		payload  string
+		modTime  time.Time
+		expected string
+		expErr   string
+	}{
+		"new content": {
+			payload:  "new-token",
+			expected: "new-token",
+		},
+		"different content": {
+			payload:  "new-token",
+			expected: "old-token",
+			expErr:   "write to %s was not observed: contents don't match what was written",
+		},
+		"different size": {
+			payload:  "new-token",
+			expected: "token",
+			expErr:   "write to %s was not observed: size is 5 bytes instead of 9",
+		},
+		"old modification time": {
+			payload:  "new-token",
+			expected: "new-token",
+			modTime:  time.Now().Add(-time.Hour),
+			expErr:   "write to %s was not observed: modification time %s is before the write",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "acl-token")
+			since := time.Now()
+			require.NoError(t, WriteFileWithPerms(path, c.expected, 0444))
+			if !c.modTime.IsZero() {
+				require.NoError(t, os.Chtimes(path, c.modTime, c.modTime))
+			}
+			err := ConfirmWrite(path, c.payload, since)
+			switch {
+			case c.expErr == "":
+				require.NoError(t, err)
+			case !c.modTime.IsZero():
+				info, statErr := os.Stat(path)
+				require.NoError(t, statErr)
+				require.EqualError(t, err, fmt.Sprintf(c.expErr, path, info.ModTime().Format(time.RFC3339Nano)))
+			default:
+				require.EqualError(t, err, fmt.Sprintf(c.expErr, path))
+			}
+		})
+	}
+}
+
+// TestConfirmWrite_Clock ensures that ConfirmWrite waits between attempts on
+// its clock.
+func TestConfirmWrite_Clock(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "acl-token")
+	require.NoError(t, WriteFileWithPerms(path, "old-token", 0444))
+	clock := NewFakeClock(time.Now())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- confirmWrite(path, "new-token", time.Now(), clock)
+	}()
+
+	require.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+	require.NoError(t, WriteFileWithPerms(path, "new-token", 0444))
+	clock.Advance(confirmWriteInterval)
+	require.NoError(t, <-errCh)
+}
+
+func TestConsulLogin_ConfirmSinkWrite(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	sink := WriteTempFile(t, "old-token")
+	_, err := ConsulLogin(LoginConfig{
+		Client:           startMockServer(t, &counter),
+		BearerTokenFile:  WriteTempFile(t, "foo"),
+		AuthMethod:       testAuthMethod,
+		TokenSinkFile:    sink,
+		Meta:             testPodMeta,
+		ConfirmSinkWrite: true,
+	})
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(sink)
+	require.NoError(t, err)
+	require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", string(data))
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// tokenEncryptionKey reads the base64 encoded AES key from the environment
+// variable envVar. The decoded key must be 16, 24 or 32 bytes long.
+func tokenEncryptionKey(envVar string) ([]byte, error) {
+	encoded := os.Getenv(envVar)
+	if encoded == "" {
+		return nil, fmt.Errorf("token encryption key environment variable %s is not set", envVar)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("token encryption key in %s is not valid base64: %s", envVar, err)
+	}
+	return key, nil
+}
+
+// encryptToken encrypts token with AES-GCM using key and returns the nonce
+// followed by the ciphertext, base64 encoded.
+func encryptToken(token string, key []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("unable to generate nonce: %s", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(token), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// ReadEncryptedToken reads a token written to path by ConsulLogin with
+// LoginConfig.TokenEncryptionKeyEnv set and decrypts it with key.
+func ReadEncryptedToken(path string, key []byte) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read encrypted token: %s", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", fmt.Errorf("encrypted token in %s is not valid base64: %s", path, err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted token is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	token, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt token: %s", err)
+	}
+	return string(token), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token encryption key: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"encoding/base64"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsulLogin_EncryptedToken(t *testing.T) {
+	require := require.New(t)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	envVar := "TEST_CONSUL_LOGIN_TOKEN_KEY"
+	os.Setenv(envVar, base64.StdEncoding.EncodeToString(key))
+	t.Cleanup(func() { os.Unsetenv(envVar) })
+
+	counter := 0
+	tokenFile := WriteTempFile(t, "")
+	_, err := ConsulLogin(LoginConfig{
+		Client:                startMockServer(t, &counter),
+		BearerTokenFile:       WriteTempFile(t, "foo"),
+		AuthMethod:            testAuthMethod,
+		TokenSinkFile:         tokenFile,
+		Meta:                  testPodMeta,
+		TokenEncryptionKeyEnv: envVar,
+	})
+	require.NoError(err)
+
+	token, err := ReadEncryptedToken(tokenFile, key)
+	require.NoError(err)
+	require.Equal("b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", token)
+}
+
+func TestReadEncryptedToken_WrongKey(t *testing.T) {
+	t.Parallel()
+	encrypted, err := encryptToken("b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", []byte("0123456789abcdef"))
+	require.NoError(t, err)
+	tokenFile := WriteTempFile(t, encrypted)
+
+	_, err = ReadEncryptedToken(tokenFile, []byte("fedcba9876543210"))
+	require.EqualError(t, err, "unable to decrypt token: cipher: message authentication failed")
+}
+
+func TestConsulLogin_EncryptionKeyNotSet(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	cfg := testLoginConfig(t, startMockServer(t, &counter))
+	cfg.TokenEncryptionKeyEnv = "TEST_CONSUL_LOGIN_TOKEN_KEY_UNSET"
+	_, err := ConsulLogin(cfg)
+	require.EqualError(t, err, "token encryption key environment variable TEST_CONSUL_LOGIN_TOKEN_KEY_UNSET is not set")
+	// We should fail before logging in.
+	require.Equal(t, 0, counter)
+}
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TokenSink is where ConsulLogin writes the ACL token. FileSink is used
+// unless LoginConfig.TokenSink is set.
+type TokenSink interface {
+	// Write stores token, replacing any token stored before.
+	Write(token string) error
+}
+
+// ContextTokenSink is a TokenSink whose writes can be cancelled, such as one
+// writing over the network. RunLoginLoop and LoginWithRetry pass their
+// context to WriteContext.
+type ContextTokenSink interface {
+	TokenSink
+	// WriteContext is Write giving up once ctx is done.
+	WriteContext(ctx context.Context, token string) error
+}
+
+// writeToSink writes token to sink, with ctx if sink is a ContextTokenSink.
+func writeToSink(ctx context.Context, sink TokenSink, token string) error {
+	if s, ok := sink.(ContextTokenSink); ok {
+		return s.WriteContext(ctx, token)
+	}
+	return sink.Write(token)
+}
+
+// FileSink is the default TokenSink. It writes the token to Path the same
+// way as LoginConfig.TokenSinkFile.
+type FileSink struct {
+	Path string
+}
+
+// Write implements TokenSink.
+func (s FileSink) Write(token string) error {
+	return writeTokenSink(s.Path, token)
+}
+
+// VaultSink is a TokenSink that stores the token in a Vault KV secrets
+// engine under the key "token", for consumers that read their secrets from
+// Vault rather than from disk.
+type VaultSink struct {
+	// Address is the address of Vault, such as https://vault.vault:8200.
+	Address string
+	// Token is the Vault token used to write the secret.
+	Token string
+	// Path is the path of the secret including the KV mount, such as
+	// secret/consul-token. For a version 2 KV engine it must include the data
+	// segment, such as secret/data/consul-token.
+	Path string
+	// KVv2 must be true if the KV engine at Path is version 2.
+	KVv2 bool
+	// HTTPClient is used to talk to Vault. If nil, a client that gives up
+	// after defaultVaultTimeout is used so that a stuck Vault doesn't hang
+	// the login.
+	HTTPClient *http.Client
+}
+
+const (
+	// maxVaultErrorBytes is how much of Vault's error response is included
+	// in the errors returned by VaultSink.
+	maxVaultErrorBytes = 1024
+	// defaultVaultTimeout bounds the requests to Vault made by a VaultSink
+	// without an HTTPClient.
+	defaultVaultTimeout = 30 * time.Second
+)
+
+// Write implements TokenSink.
+func (s VaultSink) Write(token string) error {
+	return s.WriteContext(context.Background(), token)
+}
+
+// WriteContext implements ContextTokenSink.
+func (s VaultSink) WriteContext(ctx context.Context, token string) error {
+	var body interface{} = map[string]string{"token": token}
+	if s.KVv2 {
+		body = map[string]interface{}{"data": body}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(s.Address, "/") + "/v1/" + strings.TrimPrefix(s.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unable to write token to Vault: %s", err)
+	}
+	req.Header.Set("X-Vault-Token", s.Token)
+	req.Header.Set("Content-Type", "application/json")
+	client := s.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultVaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to write token to Vault: %s", err)
+	}
+	defer drainAndClose(resp)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxVaultErrorBytes))
+		return fmt.Errorf("unable to write token to Vault at %s: unexpected response code %d: %s", s.Path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
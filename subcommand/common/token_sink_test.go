@@ -0,0 +1,138 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "acl-token")
+	require.NoError(t, FileSink{Path: path}.Write("token"))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "token", string(data))
+}
+
+func TestVaultSink(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		kvV2    bool
+		expBody string
+	}{
+		"kv v1": {expBody: `{"token":"b78d37c7-0ca7-5f4d-99ee-6d9975ce4586"}`},
+		"kv v2": {kvV2: true, expBody: `{"data":{"token":"b78d37c7-0ca7-5f4d-99ee-6d9975ce4586"}}`},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			vault := startMockVault(t)
+			counter := 0
+			_, err := ConsulLogin(LoginConfig{
+				Client:          startMockServer(t, &counter),
+				BearerTokenFile: WriteTempFile(t, "foo"),
+				AuthMethod:      testAuthMethod,
+				Meta:            testPodMeta,
+				TokenSink: VaultSink{
+					Address: vault.URL,
+					Token:   "vault-token",
+					Path:    "secret/consul-token",
+					KVv2:    c.kvV2,
+				},
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, c.expBody, vault.stored["/v1/secret/consul-token"])
+		})
+	}
+}
+
+func TestVaultSink_Error(t *testing.T) {
+	t.Parallel()
+	vault := startMockVault(t)
+	err := VaultSink{Address: vault.URL, Token: "wrong", Path: "secret/consul-token"}.Write("token")
+	require.EqualError(t, err, `unable to write token to Vault at secret/consul-token: unexpected response code 403: {"errors":["permission denied"]}`)
+	require.Empty(t, vault.stored)
+}
+
+// TestLoginWithRetry_StuckVaultSink ensures that a write to a Vault that
+// doesn't respond is given up when the login's context is done.
+func TestLoginWithRetry_StuckVaultSink(t *testing.T) {
+	t.Parallel()
+	stuck := make(chan struct{})
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stuck:
+		}
+	}))
+	t.Cleanup(vault.Close)
+	t.Cleanup(func() { close(stuck) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	counter := 0
+	_, err := LoginWithRetry(ctx, LoginConfig{
+		Client:          startMockServer(t, &counter),
+		BearerTokenFile: WriteTempFile(t, "foo"),
+		AuthMethod:      testAuthMethod,
+		Meta:            testPodMeta,
+		TokenSink:       VaultSink{Address: vault.URL, Token: "vault-token", Path: "secret/consul-token"},
+	}, time.Hour)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "context deadline exceeded")
+}
+
+func TestLoginConfig_ValidateTokenSink(t *testing.T) {
+	t.Parallel()
+	counter := 0
+	cfg := LoginConfig{
+		Client:           startMockServer(t, &counter),
+		AuthMethod:       testAuthMethod,
+		BearerTokenFile:  "/token",
+		TokenSink:        FileSink{Path: "/sink"},
+		TokenSinkFile:    "/sink",
+		WriteTokenDigest: true,
+	}
+	require.EqualError(t, cfg.Validate(), "TokenSink cannot be used with: TokenSinkFile, WriteTokenDigest")
+	cfg.TokenSinkFile = ""
+	cfg.WriteTokenDigest = false
+	require.NoError(t, cfg.Validate())
+
+	cfg.SinkPathPrefix = "/consul/connect-inject"
+	require.EqualError(t, cfg.Validate(), "TokenSink cannot be used with: SinkPathPrefix")
+}
+
+type mockVault struct {
+	*httptest.Server
+	// stored maps the paths written to to the request bodies.
+	stored map[string]string
+}
+
+// startMockVault starts a server that accepts KV writes made with the token
+// "vault-token" and records them.
+func startMockVault(t *testing.T) *mockVault {
+	t.Helper()
+	m := &mockVault{stored: make(map[string]string)}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		var body json.RawMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		m.stored[r.URL.Path] = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(m.Server.Close)
+	return m
+}
@@ -0,0 +1,39 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// serviceAccountSubjectPrefix prefixes the sub claim of Kubernetes service
+// account tokens, which is followed by <namespace>:<name>.
+const serviceAccountSubjectPrefix = "system:serviceaccount:"
+
+// ValidateTokenSubject checks that the sub claim of the JWT token refers to
+// the service account expectedSA in expectedNamespace. It is a sanity check
+// that the pod is logging in with its own service account token; the
+// token's signature is not verified, that is left to Consul.
+func ValidateTokenSubject(token, expectedNamespace, expectedSA string) error {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return errors.New("bearer token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("unable to decode bearer token claims: %s", err)
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return fmt.Errorf("unable to decode bearer token claims: %s", err)
+	}
+	expected := serviceAccountSubjectPrefix + expectedNamespace + ":" + expectedSA
+	if claims.Sub != expected {
+		return fmt.Errorf("bearer token subject %q does not match service account %s/%s", claims.Sub, expectedNamespace, expectedSA)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTokenSubject(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		token     string
+		namespace string
+		sa        string
+		expErr    string
+	}{
+		"matching": {
+			token:     testJWT,
+			namespace: "default",
+			sa:        "example",
+		},
+		"mismatching service account": {
+			token:     testJWT,
+			namespace: "default",
+			sa:        "other",
+			expErr:    `bearer token subject "system:serviceaccount:default:example" does not match service account default/other`,
+		},
+		"mismatching namespace": {
+			token:     testJWT,
+			namespace: "kube-system",
+			sa:        "example",
+			expErr:    `bearer token subject "system:serviceaccount:default:example" does not match service account kube-system/example`,
+		},
+		"not a JWT": {
+			token:     "foo",
+			namespace: "default",
+			sa:        "example",
+			expErr:    "bearer token is not a JWT",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			err := ValidateTokenSubject(c.token, c.namespace, c.sa)
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/debug"
+
+	"github.com/hashicorp/consul-k8s/version"
+)
+
+// consulAPIModule is the module path of the Consul API client we're built with.
+const consulAPIModule = "github.com/hashicorp/consul/api"
+
+// VersionInfo describes the version of consul-k8s that is running.
+type VersionInfo struct {
+	// Version is the human readable consul-k8s version.
+	Version string `json:"version"`
+	// GitCommit is the git SHA consul-k8s was built from, if known.
+	GitCommit string `json:"gitCommit"`
+	// ConsulAPIVersion is the version of the Consul API client module
+	// consul-k8s was built with, or "unknown" if it can't be determined.
+	ConsulAPIVersion string `json:"consulAPIVersion"`
+}
+
+// GetVersionInfo returns the VersionInfo for this binary.
+func GetVersionInfo() VersionInfo {
+	info := VersionInfo{
+		Version:          version.GetHumanVersion(),
+		GitCommit:        version.GitCommit,
+		ConsulAPIVersion: "unknown",
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range bi.Deps {
+			if dep.Path == consulAPIModule {
+				info.ConsulAPIVersion = dep.Version
+				if dep.Replace != nil {
+					info.ConsulAPIVersion = dep.Replace.Version
+				}
+				break
+			}
+		}
+	}
+	return info
+}
+
+// PrintVersion writes the version of consul-k8s to w, either in the same
+// human readable form as the version command or as JSON if jsonOut is true.
+func PrintVersion(w io.Writer, jsonOut bool) error {
+	info := GetVersionInfo()
+	if jsonOut {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	_, err := fmt.Fprintf(w, "consul-k8s %s\nConsul API client: %s\n", info.Version, info.ConsulAPIVersion)
+	return err
+}
@@ -0,0 +1,32 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/version"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintVersion(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	require.NoError(t, PrintVersion(&buf, false))
+	require.Contains(t, buf.String(), "consul-k8s "+version.GetHumanVersion())
+	require.Contains(t, buf.String(), "Consul API client: ")
+}
+
+func TestPrintVersion_JSON(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	require.NoError(t, PrintVersion(&buf, true))
+
+	var out map[string]string
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	require.Contains(t, out, "version")
+	require.Contains(t, out, "gitCommit")
+	require.Contains(t, out, "consulAPIVersion")
+	require.Equal(t, version.GetHumanVersion(), out["version"])
+	require.NotEmpty(t, out["consulAPIVersion"])
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WaitForTokenAccepted calls checkFn every interval until it reports that the
+// token has been accepted or ctx is done. It is used after writing a token to
+// wait for a consumer, such as Envoy, to pick it up. Errors from checkFn are
+// treated as "not accepted yet" and the last one is included in the error
+// returned if ctx is done first.
+func WaitForTokenAccepted(ctx context.Context, checkFn func() (bool, error), interval time.Duration) error {
+	return waitForTokenAccepted(ctx, checkFn, interval, RealClock{})
+}
+
+// waitForTokenAccepted is WaitForTokenAccepted waiting interval on clock.
+func waitForTokenAccepted(ctx context.Context, checkFn func() (bool, error), interval time.Duration, clock Clock) error {
+	var lastErr error
+	for {
+		accepted, err := checkFn()
+		if err == nil && accepted {
+			return nil
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("token was not accepted: %s (last error: %s)", ctx.Err(), lastErr)
+			}
+			return fmt.Errorf("token was not accepted: %s", ctx.Err())
+		case <-clock.After(interval):
+		}
+	}
+}
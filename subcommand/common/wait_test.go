@@ -0,0 +1,60 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForTokenAccepted(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	check := func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := WaitForTokenAccepted(ctx, check, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+}
+
+func TestWaitForTokenAccepted_Deadline(t *testing.T) {
+	t.Parallel()
+	check := func() (bool, error) {
+		return false, errors.New("envoy not ready")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := WaitForTokenAccepted(ctx, check, 10*time.Millisecond)
+	require.EqualError(t, err, "token was not accepted: context deadline exceeded (last error: envoy not ready)")
+}
+
+func TestWaitForTokenAccepted_Clock(t *testing.T) {
+	t.Parallel()
+	clock := NewFakeClock(time.Now())
+	var calls int32
+	check := func() (bool, error) {
+		return atomic.AddInt32(&calls, 1) == 3, nil
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- waitForTokenAccepted(context.Background(), check, time.Minute, clock)
+	}()
+
+	// Each check is only made once the interval has passed on the clock.
+	for i := int32(1); i < 3; i++ {
+		require.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+		require.Equal(t, i, atomic.LoadInt32(&calls))
+		clock.Advance(time.Minute)
+	}
+	require.NoError(t, <-errCh)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
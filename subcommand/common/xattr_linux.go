@@ -0,0 +1,33 @@
+package common
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// WriteTokenXattr stores token in the extended attribute attr of the file at
+// path, for security tooling that reads tokens from xattrs rather than file
+// contents. attr must include its namespace, for example "user.consul-token".
+// The file must already exist and its filesystem must support xattrs; if it
+// doesn't, the returned error wraps syscall.ENOTSUP.
+func WriteTokenXattr(path, attr, token string) error {
+	if err := syscall.Setxattr(path, attr, []byte(token), 0); err != nil {
+		return fmt.Errorf("unable to set extended attribute %s on %s: %w", attr, path, err)
+	}
+	return nil
+}
+
+// ReadTokenXattr returns the token stored by WriteTokenXattr in the extended
+// attribute attr of the file at path.
+func ReadTokenXattr(path, attr string) (string, error) {
+	size, err := syscall.Getxattr(path, attr, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to get extended attribute %s on %s: %s", attr, path, err)
+	}
+	buf := make([]byte, size)
+	n, err := syscall.Getxattr(path, attr, buf)
+	if err != nil {
+		return "", fmt.Errorf("unable to get extended attribute %s on %s: %s", attr, path, err)
+	}
+	return string(buf[:n]), nil
+}
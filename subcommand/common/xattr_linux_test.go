@@ -0,0 +1,31 @@
+package common
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTokenXattr(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	path := WriteTempFile(t, "")
+
+	err := WriteTokenXattr(path, "user.consul-token", "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586")
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("filesystem does not support extended attributes")
+	}
+	require.NoError(err)
+
+	token, err := ReadTokenXattr(path, "user.consul-token")
+	require.NoError(err)
+	require.Equal("b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", token)
+}
+
+func TestReadTokenXattr_Missing(t *testing.T) {
+	t.Parallel()
+	_, err := ReadTokenXattr(WriteTempFile(t, ""), "user.consul-token")
+	require.Error(t, err)
+}
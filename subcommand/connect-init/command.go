@@ -3,22 +3,25 @@ package connectinit
 import (
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
 	connectinject "github.com/hashicorp/consul-k8s/connect-inject"
-	"github.com/hashicorp/consul-k8s/consul"
+	"github.com/hashicorp/consul-k8s/helper/meta"
 	"github.com/hashicorp/consul-k8s/subcommand/common"
 	"github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
-	defaultBearerTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultBearerTokenFile = common.DefaultBearerTokenFile
 	defaultTokenSinkFile   = "/consul/connect-inject/acl-token"
 	defaultProxyIDFile     = "/consul/connect-inject/proxyid"
 
@@ -39,6 +42,8 @@ type Command struct {
 	flagServiceAccountName     string // Service account name.
 	flagServiceName            string // Service name.
 	flagLogLevel               string
+	flagMetricsAddr            string // Address to serve login metrics on.
+	flagConfigFile             string // Path to a file with login settings.
 
 	bearerTokenFile                    string // Location of the bearer token. Default is /var/run/secrets/kubernetes.io/serviceaccount/token.
 	tokenSinkFile                      string // Location to write the output token. Default is defaultTokenSinkFile.
@@ -47,6 +52,7 @@ type Command struct {
 
 	flagSet *flag.FlagSet
 	http    *flags.HTTPFlags
+	log     *flags.LogFlags
 
 	once   sync.Once
 	help   string
@@ -65,6 +71,16 @@ func (c *Command) init() {
 	c.flagSet.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
+	c.flagSet.StringVar(&c.flagMetricsAddr, "metrics-addr", "",
+		"If set, the address, such as 127.0.0.1:20200, to serve Prometheus metrics on "+
+			"for as long as the command runs. The metrics include the latency and "+
+			"outcome of each Consul login.")
+
+	c.flagSet.StringVar(&c.flagConfigFile, "config-file", "",
+		"Path to a YAML or JSON file with login settings for setups too complex for "+
+			"flags. Logging in is enabled if it sets an auth method. -acl-auth-method "+
+			"and -auth-method-namespace override the file and its meta is merged "+
+			"with the pod's.")
 
 	if c.bearerTokenFile == "" {
 		c.bearerTokenFile = defaultBearerTokenFile
@@ -80,7 +96,9 @@ func (c *Command) init() {
 	}
 
 	c.http = &flags.HTTPFlags{}
+	c.log = &flags.LogFlags{}
 	flags.Merge(c.flagSet, c.http.Flags())
+	flags.Merge(c.flagSet, c.log.Flags())
 	c.help = flags.Usage(help, c.flagSet)
 
 }
@@ -100,16 +118,26 @@ func (c *Command) Run(args []string) int {
 		c.UI.Error("-pod-namespace must be set")
 		return 1
 	}
-	if c.flagACLAuthMethod != "" && c.flagServiceAccountName == "" {
+	loginCfg, err := c.loginConfig()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	aclsEnabled := loginCfg.AuthMethod != "" || loginCfg.AuthMethodFile != ""
+	if aclsEnabled && c.flagServiceAccountName == "" {
 		fmt.Println(c.flagServiceAccountName)
 		c.UI.Error("-service-account-name must be set when ACLs are enabled")
 		return 1
 	}
+	if err := c.log.Validate(c.flagLogLevel); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	// Set up logging.
 	if c.logger == nil {
 		var err error
-		c.logger, err = common.Logger(c.flagLogLevel)
+		c.logger, err = common.LoggerWithQuiet(c.flagLogLevel, c.log.Quiet())
 		if err != nil {
 			c.UI.Error(err.Error())
 			return 1
@@ -118,18 +146,51 @@ func (c *Command) Run(args []string) int {
 	cfg := api.DefaultConfig()
 	cfg.Namespace = c.flagConsulServiceNamespace
 	c.http.MergeOntoConfig(cfg)
-	consulClient, err := consul.NewClient(cfg)
+	// -http-addr can list several addresses to fail over between.
+	addrs := c.http.Addrs()
+	if len(addrs) == 0 {
+		addrs = []string{cfg.Address}
+	}
+	consulClients, err := common.ConsulClientFromAddrs(cfg, addrs)
 	if err != nil {
 		c.logger.Error("Unable to get client connection", "error", err)
 		return 1
 	}
+	consulClient := consulClients[0]
+
+	var loginMetrics *common.LoginMetrics
+	if c.flagMetricsAddr != "" {
+		reg := prometheus.NewRegistry()
+		loginMetrics, err = common.RegisterLoginMetrics(reg)
+		if err != nil {
+			c.logger.Error("Unable to register login metrics", "error", err)
+			return 1
+		}
+		// Listen before serving so that an unusable address fails the command.
+		ln, err := net.Listen("tcp", c.flagMetricsAddr)
+		if err != nil {
+			c.logger.Error("Unable to listen for metrics requests", "error", err)
+			return 1
+		}
+		server := common.MetricsServer(c.flagMetricsAddr, reg)
+		go func() {
+			if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+				c.logger.Error("Metrics server error", "error", err)
+			}
+		}()
+		defer server.Close()
+	}
 
 	// First do the ACL Login, if necessary.
-	if c.flagACLAuthMethod != "" {
-		// loginMeta is the default metadata that we pass to the consul login API.
-		loginMeta := map[string]string{"pod": fmt.Sprintf("%s/%s", c.flagPodNamespace, c.flagPodName)}
+	if aclsEnabled {
+		loginCfg.Client = consulClient
+		loginCfg.FailoverClients = consulClients[1:]
+		loginCfg.Metrics = loginMetrics
+		// Only the final error is diagnosed so that every retry doesn't
+		// make more requests to a failing Consul.
+		loginCfg.SkipDiagnostics = true
 		err = backoff.Retry(func() error {
-			err := common.ConsulLogin(consulClient, c.bearerTokenFile, c.flagACLAuthMethod, c.tokenSinkFile, c.flagAuthMethodNamespace, loginMeta)
+			_, err := common.ConsulLogin(loginCfg)
 			if err != nil {
 				c.logger.Error("Consul login failed; retrying", "error", err)
 			}
@@ -137,15 +198,17 @@ func (c *Command) Run(args []string) int {
 		}, backoff.WithMaxRetries(backoff.NewConstantBackOff(1*time.Second), numLoginRetries))
 		if err != nil {
 			c.logger.Error("Hit maximum retries for consul login", "error", err)
+			c.logger.Error(common.DiagnoseLoginFailure(consulClient, loginCfg))
 			return 1
 		}
 		// Now update the client so that it will read the ACL token we just fetched.
-		cfg.TokenFile = c.tokenSinkFile
-		consulClient, err = consul.NewClient(cfg)
+		cfg.TokenFile = loginCfg.SinkPath()
+		consulClients, err = common.ConsulClientFromAddrs(cfg, addrs)
 		if err != nil {
 			c.logger.Error("Unable to update client connection", "error", err)
 			return 1
 		}
+		consulClient = consulClients[0]
 		c.logger.Info("Consul login complete")
 	}
 
@@ -160,6 +223,11 @@ func (c *Command) Run(args []string) int {
 		serviceList, err := consulClient.Agent().ServicesWithFilter(filter)
 		if err != nil {
 			c.logger.Error("Unable to get Agent services", "error", err)
+			if common.IsTransportErr(err) {
+				// Try the next -http-addr address on the next attempt.
+				consulClients = append(consulClients[1:], consulClient)
+				consulClient = consulClients[0]
+			}
 			return err
 		}
 		// Wait for the service and the connect-proxy service to be registered.
@@ -174,7 +242,7 @@ func (c *Command) Run(args []string) int {
 		}
 		for _, svc := range serviceList {
 			c.logger.Info("Registered service has been detected", "service", svc.Service)
-			if c.flagACLAuthMethod != "" {
+			if aclsEnabled {
 				if c.flagServiceName != "" && c.flagServiceAccountName != c.flagServiceName {
 					// Set the error but return nil so we don't retry.
 					errServiceNameMismatch = fmt.Errorf("service account name %s doesn't match annotation service name %s", c.flagServiceAccountName, c.flagServiceName)
@@ -219,6 +287,38 @@ func (c *Command) Run(args []string) int {
 	return 0
 }
 
+// loginConfig returns the settings to log in with: those of -config-file,
+// if set, combined with the flags.
+func (c *Command) loginConfig() (common.LoginConfig, error) {
+	var cfg common.LoginConfig
+	if c.flagConfigFile != "" {
+		var err error
+		if cfg, err = common.LoadLoginConfig(c.flagConfigFile); err != nil {
+			return cfg, err
+		}
+	}
+	if c.flagACLAuthMethod != "" {
+		cfg.AuthMethod = c.flagACLAuthMethod
+	}
+	if c.flagAuthMethodNamespace != "" {
+		cfg.Namespace = c.flagAuthMethodNamespace
+	}
+	if cfg.BearerTokenFile == "" && len(cfg.BearerTokenFiles) == 0 {
+		cfg.BearerTokenFile = c.bearerTokenFile
+	}
+	if cfg.TokenSinkFile == "" && cfg.TokenSinkDir == "" {
+		cfg.TokenSinkFile = c.tokenSinkFile
+	}
+	// loginMeta is the default metadata that we pass to the consul login API.
+	loginMeta := common.PodLoginMeta(c.flagPodNamespace, c.flagPodName, "")
+	merged, err := meta.MergeMeta(loginMeta, cfg.Meta)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid meta in %s: %s", c.flagConfigFile, err)
+	}
+	cfg.Meta = merged
+	return cfg, nil
+}
+
 func (c *Command) Synopsis() string { return synopsis }
 func (c *Command) Help() string {
 	c.once.Do(c.init)
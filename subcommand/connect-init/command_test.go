@@ -1,13 +1,16 @@
 package connectinit
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -36,10 +39,18 @@ func TestRun_FlagValidation(t *testing.T) {
 			flags:  []string{"-pod-name", testPodName, "-pod-namespace", testPodNamespace, "-acl-auth-method", testAuthMethod},
 			expErr: "-service-account-name must be set when ACLs are enabled",
 		},
+		{
+			flags:  []string{"-pod-name", testPodName, "-pod-namespace", testPodNamespace, "-config-file", "/does/not/exist.yaml"},
+			expErr: "unable to read login config file",
+		},
 		{
 			flags:  []string{"-pod-name", testPodName, "-pod-namespace", testPodNamespace, "-acl-auth-method", testAuthMethod, "-service-account-name", "foo", "-log-level", "invalid"},
 			expErr: "unknown log level: invalid",
 		},
+		{
+			flags:  []string{"-pod-name", testPodName, "-pod-namespace", testPodNamespace, "-log-level", "debug", "-quiet"},
+			expErr: "-quiet cannot be used with -log-level=debug",
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.expErr, func(t *testing.T) {
@@ -736,6 +747,230 @@ func TestRun_LoginWithRetries(t *testing.T) {
 	}
 }
 
+// TestRun_DiagnosesFinalLoginError ensures that the diagnostic requests are
+// only made once all login retries have failed rather than on every retry.
+func TestRun_DiagnosesFinalLoginError(t *testing.T) {
+	t.Parallel()
+	var logins, leaderRequests int
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/acl/login":
+			logins++
+		case "/v1/status/leader":
+			leaderRequests++
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer consulServer.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:              ui,
+		tokenSinkFile:   common.WriteTempFile(t, ""),
+		bearerTokenFile: common.WriteTempFile(t, "bearerTokenFile"),
+		proxyIDFile:     common.WriteTempFile(t, ""),
+	}
+	code := cmd.Run([]string{
+		"-pod-name", testPodName,
+		"-pod-namespace", testPodNamespace,
+		"-acl-auth-method", testAuthMethod,
+		"-service-account-name", testServiceAccountName,
+		"-http-addr", consulServer.URL})
+	require.Equal(t, 1, code)
+	require.Equal(t, numLoginRetries+1, logins)
+	require.Equal(t, 1, leaderRequests)
+}
+
+// TestRun_FailoverHTTPAddrs ensures that the other addresses given to
+// -http-addr are used when the first one is unreachable.
+func TestRun_FailoverHTTPAddrs(t *testing.T) {
+	t.Parallel()
+	bearerFile := common.WriteTempFile(t, "bearerTokenFile")
+	tokenFile := common.WriteTempFile(t, "")
+	proxyFile := common.WriteTempFile(t, "")
+
+	// The first address doesn't accept connections.
+	downServer := httptest.NewServer(http.NotFoundHandler())
+	downServer.Close()
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ACL Login.
+		if r != nil && r.URL.Path == "/v1/acl/login" && r.Method == "POST" {
+			w.Write([]byte(testLoginResponse))
+		}
+		// Agent Services get.
+		if r != nil && r.URL.Path == "/v1/agent/services" && r.Method == "GET" {
+			w.Write([]byte(testServiceListResponse))
+		}
+	}))
+	defer consulServer.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:              ui,
+		tokenSinkFile:   tokenFile,
+		bearerTokenFile: bearerFile,
+		proxyIDFile:     proxyFile,
+	}
+	code := cmd.Run([]string{
+		"-pod-name", testPodName,
+		"-pod-namespace", testPodNamespace,
+		"-acl-auth-method", testAuthMethod,
+		"-service-account-name", testServiceAccountName,
+		"-http-addr", downServer.URL + "," + consulServer.URL})
+	require.Equal(t, 0, code, ui.ErrorWriter.String())
+	tokenData, err := ioutil.ReadFile(tokenFile)
+	require.NoError(t, err)
+	require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", string(tokenData))
+	proxydata, err := ioutil.ReadFile(proxyFile)
+	require.NoError(t, err)
+	require.Equal(t, "counting-counting-sidecar-proxy", string(proxydata))
+}
+
+// TestRun_NoFailoverOnErrorResponse ensures that an error response from the
+// first -http-addr address doesn't make the command use the others.
+func TestRun_NoFailoverOnErrorResponse(t *testing.T) {
+	t.Parallel()
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer forbidden.Close()
+	otherRequests := 0
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherRequests++
+		w.Write([]byte(testServiceListResponse))
+	}))
+	defer other.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:                                 ui,
+		proxyIDFile:                        common.WriteTempFile(t, ""),
+		serviceRegistrationPollingAttempts: 2,
+	}
+	code := cmd.Run([]string{
+		"-pod-name", testPodName,
+		"-pod-namespace", testPodNamespace,
+		"-http-addr", forbidden.URL + "," + other.URL})
+	require.Equal(t, 1, code)
+	require.Zero(t, otherRequests)
+}
+
+// TestRun_MetricsAddr ensures that the login metrics are served on
+// -metrics-addr while the command runs.
+func TestRun_MetricsAddr(t *testing.T) {
+	t.Parallel()
+	bearerFile := common.WriteTempFile(t, "bearerTokenFile")
+	tokenFile := common.WriteTempFile(t, "")
+	proxyFile := common.WriteTempFile(t, "")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	metricsAddr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	// The first login fails and the second one scrapes the metrics before
+	// succeeding, so the metrics show the failed login.
+	logins := 0
+	var metrics string
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ACL Login.
+		if r != nil && r.URL.Path == "/v1/acl/login" && r.Method == "POST" {
+			logins++
+			if logins == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			resp, err := http.Get("http://" + metricsAddr + "/metrics")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			metrics = string(body)
+			w.Write([]byte(testLoginResponse))
+		}
+		// Agent Services get.
+		if r != nil && r.URL.Path == "/v1/agent/services" && r.Method == "GET" {
+			w.Write([]byte(testServiceListResponse))
+		}
+	}))
+	defer consulServer.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:              ui,
+		tokenSinkFile:   tokenFile,
+		bearerTokenFile: bearerFile,
+		proxyIDFile:     proxyFile,
+	}
+	code := cmd.Run([]string{
+		"-pod-name", testPodName,
+		"-pod-namespace", testPodNamespace,
+		"-acl-auth-method", testAuthMethod,
+		"-service-account-name", testServiceAccountName,
+		"-http-addr", consulServer.URL,
+		"-metrics-addr", metricsAddr})
+	require.Equal(t, 0, code, ui.ErrorWriter.String())
+	require.Equal(t, 2, logins)
+	require.Contains(t, metrics, `consul_k8s_login_total{outcome="failure"} 1`)
+	require.Contains(t, metrics, "consul_k8s_login_duration_seconds_count 1")
+
+	// The metrics server is stopped when the command exits.
+	_, err = http.Get("http://" + metricsAddr + "/metrics")
+	require.Error(t, err)
+}
+
+// TestRun_ConfigFile ensures that logins use the settings of -config-file.
+func TestRun_ConfigFile(t *testing.T) {
+	t.Parallel()
+	bearerFile := common.WriteTempFile(t, "bearerTokenFile")
+	tokenFile := common.WriteTempFile(t, "")
+	proxyFile := common.WriteTempFile(t, "")
+	configFile := filepath.Join(t.TempDir(), "login.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(`
+authMethod: `+testAuthMethod+`
+meta:
+  cluster: dc1
+`), 0600))
+
+	var params api.ACLLoginParams
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ACL Login.
+		if r != nil && r.URL.Path == "/v1/acl/login" && r.Method == "POST" {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+			w.Write([]byte(testLoginResponse))
+		}
+		// Agent Services get.
+		if r != nil && r.URL.Path == "/v1/agent/services" && r.Method == "GET" {
+			w.Write([]byte(testServiceListResponse))
+		}
+	}))
+	defer consulServer.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:              ui,
+		tokenSinkFile:   tokenFile,
+		bearerTokenFile: bearerFile,
+		proxyIDFile:     proxyFile,
+	}
+	code := cmd.Run([]string{
+		"-pod-name", testPodName,
+		"-pod-namespace", testPodNamespace,
+		"-service-account-name", testServiceAccountName,
+		"-http-addr", consulServer.URL,
+		"-config-file", configFile})
+	require.Equal(t, 0, code, ui.ErrorWriter.String())
+	require.Equal(t, testAuthMethod, params.AuthMethod)
+	require.Equal(t, "bearerTokenFile", params.BearerToken)
+	require.Equal(t, map[string]string{
+		"pod":     testPodNamespace + "/" + testPodName,
+		"cluster": "dc1",
+	}, params.Meta)
+	tokenData, err := ioutil.ReadFile(tokenFile)
+	require.NoError(t, err)
+	require.Equal(t, "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586", string(tokenData))
+}
+
 const (
 	metaKeyPodName         = "pod-name"
 	metaKeyKubeNS          = "k8s-namespace"
@@ -1,2 +1,49 @@
 // Package flags holds common flags that are shared between our commands.
 package flags
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// SafeRegister registers value as the flag name on fs, like fs.Var, but
+// returns an error rather than panicking if name is already registered.
+func SafeRegister(fs *flag.FlagSet, name string, value flag.Value, usage string) error {
+	if fs.Lookup(name) != nil {
+		return fmt.Errorf("flag -%s is already registered", name)
+	}
+	fs.Var(value, name, usage)
+	return nil
+}
+
+// ExpandEnvFlags runs os.ExpandEnv over the value of every string flag that
+// was set on fs, so that operators can pass values such as
+// -http-addr=${CONSUL_HTTP_ADDR}. It must be called after fs.Parse.
+func ExpandEnvFlags(fs *flag.FlagSet) error {
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		var value string
+		switch v := f.Value.(type) {
+		case *StringValue:
+			value = v.String()
+		case flag.Getter:
+			s, ok := v.Get().(string)
+			if !ok {
+				return
+			}
+			value = s
+		default:
+			return
+		}
+		if expanded := os.ExpandEnv(value); expanded != value {
+			if setErr := fs.Set(f.Name, expanded); setErr != nil {
+				err = fmt.Errorf("unable to expand flag -%s: %s", f.Name, setErr)
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,49 @@
+package flags
+
+import (
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafeRegister(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	var first, second StringValue
+
+	require.NoError(t, SafeRegister(fs, "http-addr", &first, "first"))
+	err := SafeRegister(fs, "http-addr", &second, "second")
+	require.EqualError(t, err, "flag -http-addr is already registered")
+
+	// The original flag should be left in place.
+	require.NoError(t, fs.Parse([]string{"-http-addr", "localhost:8500"}))
+	require.Equal(t, "localhost:8500", first.String())
+	require.Equal(t, "", second.String())
+	require.Equal(t, "first", fs.Lookup("http-addr").Usage)
+}
+
+func TestExpandEnvFlags(t *testing.T) {
+	os.Setenv("EXPAND_ENV_FLAGS_ADDR", "consul.example.com:8501")
+	defer os.Unsetenv("EXPAND_ENV_FLAGS_ADDR")
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	var addr StringValue
+	fs.Var(&addr, "http-addr", "")
+	name := fs.String("name", "", "")
+	unset := fs.String("unset", "${EXPAND_ENV_FLAGS_ADDR}", "")
+	port := fs.Int("port", 0, "")
+
+	require.NoError(t, fs.Parse([]string{
+		"-http-addr", "https://${EXPAND_ENV_FLAGS_ADDR}",
+		"-name", "$EXPAND_ENV_FLAGS_ADDR",
+		"-port", "8500",
+	}))
+	require.NoError(t, ExpandEnvFlags(fs))
+
+	require.Equal(t, "https://consul.example.com:8501", addr.String())
+	require.Equal(t, "consul.example.com:8501", *name)
+	// Flags that weren't passed keep their default untouched.
+	require.Equal(t, "${EXPAND_ENV_FLAGS_ADDR}", *unset)
+	require.Equal(t, 8500, *port)
+}
@@ -32,7 +32,10 @@ func (f *HTTPFlags) Flags() *flag.FlagSet {
 			"address or DNS address, but it must also include the port. This can "+
 			"also be specified via the CONSUL_HTTP_ADDR environment variable. The "+
 			"default value is http://127.0.0.1:8500. The scheme can also be set to "+
-			"HTTPS by setting the environment variable CONSUL_HTTP_SSL=true.")
+			"HTTPS by setting the environment variable CONSUL_HTTP_SSL=true. "+
+			"Several addresses can be given as a comma separated list, in which "+
+			"case the first one is used and commands that support it fail over "+
+			"to the others.")
 	fs.Var(&f.token, "token",
 		"ACL token to use in the request. This can also be specified via the "+
 			"CONSUL_HTTP_TOKEN environment variable. If unspecified, the query will "+
@@ -63,6 +66,18 @@ func (f *HTTPFlags) Addr() string {
 	return f.address.String()
 }
 
+// Addrs returns the addresses given to -http-addr as a comma separated list,
+// in order and without empty entries. It is empty if -http-addr isn't set.
+func (f *HTTPFlags) Addrs() []string {
+	var addrs []string
+	for _, addr := range strings.Split(f.address.String(), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 func (f *HTTPFlags) Token() string {
 	return f.token.String()
 }
@@ -102,7 +117,9 @@ func (f *HTTPFlags) APIClient() (*api.Client, error) {
 }
 
 func (f *HTTPFlags) MergeOntoConfig(c *api.Config) {
-	f.address.Merge(&c.Address)
+	if addrs := f.Addrs(); len(addrs) > 0 {
+		c.Address = addrs[0]
+	}
 	f.token.Merge(&c.Token)
 	f.tokenFile.Merge(&c.TokenFile)
 	f.caFile.Merge(&c.TLSConfig.CAFile)
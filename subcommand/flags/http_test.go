@@ -3,6 +3,7 @@ package flags
 import (
 	"testing"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,3 +17,38 @@ func TestHTTPFlagsSetToken(t *testing.T) {
 	require.NoError(f.SetToken("foo"))
 	require.Equal("foo", f.Token())
 }
+
+func TestHTTPFlagsAddrs(t *testing.T) {
+	cases := map[string]struct {
+		addr    string
+		expAddr string
+		expList []string
+	}{
+		"unset": {
+			expAddr: "127.0.0.1:8500",
+		},
+		"single address": {
+			addr:    "http://consul:8500",
+			expAddr: "http://consul:8500",
+			expList: []string{"http://consul:8500"},
+		},
+		"several addresses": {
+			addr:    "http://consul-1:8500, http://consul-2:8500,,http://consul-3:8500",
+			expAddr: "http://consul-1:8500",
+			expList: []string{"http://consul-1:8500", "http://consul-2:8500", "http://consul-3:8500"},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var f HTTPFlags
+			if c.addr != "" {
+				require.NoError(t, f.Flags().Parse([]string{"-http-addr", c.addr}))
+			}
+			require.Equal(t, c.expList, f.Addrs())
+
+			cfg := &api.Config{Address: "127.0.0.1:8500"}
+			f.MergeOntoConfig(cfg)
+			require.Equal(t, c.expAddr, cfg.Address)
+		})
+	}
+}
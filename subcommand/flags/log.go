@@ -0,0 +1,40 @@
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// LogFlags are flags used to configure logging output in addition to each
+// command's -log-level flag.
+type LogFlags struct {
+	quiet bool
+}
+
+func (f *LogFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.BoolVar(&f.quiet, "quiet", false,
+		"Only log errors, regardless of -log-level. Useful when the output is consumed by automation.")
+	return fs
+}
+
+func (f *LogFlags) Quiet() bool {
+	return f.quiet
+}
+
+// Validate returns an error if the logging flags conflict with level, the
+// value of the command's -log-level flag. -quiet takes precedence over
+// -log-level=info, warn and error, but asking for debug or trace output while
+// also asking for quiet output is an error rather than silently dropping the
+// debug logs.
+func (f *LogFlags) Validate(level string) error {
+	if !f.quiet {
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug", "trace":
+		return fmt.Errorf("-quiet cannot be used with -log-level=%s", level)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package flags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogFlags_Validate(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		level  string
+		expErr string
+	}{
+		"not quiet with debug": {
+			level: "debug",
+		},
+		"quiet with info": {
+			args:  []string{"-quiet"},
+			level: "info",
+		},
+		"quiet with error": {
+			args:  []string{"-quiet"},
+			level: "error",
+		},
+		"quiet with debug": {
+			args:   []string{"-quiet"},
+			level:  "debug",
+			expErr: "-quiet cannot be used with -log-level=debug",
+		},
+		"quiet with trace": {
+			args:   []string{"-quiet"},
+			level:  "TRACE",
+			expErr: "-quiet cannot be used with -log-level=TRACE",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var f LogFlags
+			require.NoError(t, f.Flags().Parse(c.args))
+			err := f.Validate(c.level)
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
@@ -45,7 +45,9 @@ HTTP API Options
      This can also be specified via the CONSUL_HTTP_ADDR environment
      variable. The default value is http://127.0.0.1:8500. The scheme
      can also be set to HTTPS by setting the environment variable
-     CONSUL_HTTP_SSL=true.
+     CONSUL_HTTP_SSL=true. Several addresses can be given as a comma
+     separated list, in which case the first one is used and commands
+     that support it fail over to the others.
 
   -tls-server-name=<value>
      The server name to use as the SNI host when connecting via
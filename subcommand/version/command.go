@@ -1,25 +1,61 @@
 package version
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
+	"strings"
+	"sync"
 
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/mitchellh/cli"
 )
 
 type Command struct {
-	UI      cli.Ui
-	Version string
+	UI cli.Ui
+
+	flags    *flag.FlagSet
+	flagJSON bool
+
+	once sync.Once
+	help string
 }
 
-func (c *Command) Run(_ []string) int {
-	c.UI.Output(fmt.Sprintf("consul-k8s %s", c.Version))
-	return 0
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.BoolVar(&c.flagJSON, "json", false,
+		"Output the version information as JSON, including the git commit and "+
+			"the version of the Consul API client consul-k8s was built with.")
+	c.help = flags.Usage(help, c.flags)
 }
 
-func (c *Command) Synopsis() string {
-	return "Prints the version"
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+
+	var buf bytes.Buffer
+	if err := common.PrintVersion(&buf, c.flagJSON); err != nil {
+		c.UI.Error(fmt.Sprintf("Error printing version: %s", err))
+		return 1
+	}
+	c.UI.Output(strings.TrimSpace(buf.String()))
+	return 0
 }
 
+func (c *Command) Synopsis() string { return synopsis }
 func (c *Command) Help() string {
-	return ""
+	c.once.Do(c.init)
+	return c.help
 }
+
+const synopsis = "Prints the version"
+const help = `
+Usage: consul-k8s version [options]
+
+  Prints the version of consul-k8s and of the Consul API client it was
+  built with.
+
+`
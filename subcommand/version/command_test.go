@@ -0,0 +1,36 @@
+package version
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/subcommand/common"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 0, cmd.Run(nil))
+	info := common.GetVersionInfo()
+	require.Equal(t, fmt.Sprintf("consul-k8s %s\nConsul API client: %s\n", info.Version, info.ConsulAPIVersion), ui.OutputWriter.String())
+}
+
+func TestRun_JSON(t *testing.T) {
+	t.Parallel()
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 0, cmd.Run([]string{"-json"}))
+	var info common.VersionInfo
+	require.NoError(t, json.Unmarshal(ui.OutputWriter.Bytes(), &info))
+	require.Equal(t, common.GetVersionInfo(), info)
+}
+
+func TestHelp(t *testing.T) {
+	t.Parallel()
+	cmd := Command{UI: cli.NewMockUi()}
+	require.Contains(t, cmd.Help(), "-json")
+}